@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (application *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var qsValues struct {
+		Search    string
+		Activated *bool
+		data.FilterOptions
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	qsValues.Search = application.readStringValue(qs, "q", "")
+	qsValues.Activated = application.readBool(qs, "activated", v)
+
+	qsValues.FilterOptions.Page = application.readInt(qs, "page", 1, v)
+	qsValues.FilterOptions.PageSize = application.readInt(qs, "page_size", 20, v)
+	qsValues.FilterOptions.Sort = application.readStringValue(qs, "sort", "id")
+
+	qsValues.FilterOptions.SortableValues = []string{"id", "email", "name", "created_at", "-id", "-email", "-name", "-created_at"}
+
+	if data.ValidateFilters(v, qsValues.FilterOptions); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := application.models.Users.GetAll(qsValues.Search, qsValues.Activated, qsValues.FilterOptions)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// Admins need the version to make changes, which the plain user JSON leaves out.
+	type userSummary struct {
+		*data.User
+		Version int `json:"version"`
+	}
+
+	summaries := make([]userSummary, len(users))
+	for i, user := range users {
+		summaries[i] = userSummary{User: user, Version: user.Version}
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "users": summaries}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := application.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := application.newAccountResponse(user)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"user": account}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserHandler activates, disables or re-enables an account. Disabling also
+// revokes the user's tokens and API keys, so they are signed out rather than just
+// refused on every request.
+func (application *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := application.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+		Disabled  *bool `json:"disabled"`
+		Version   *int  `json:"version"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Version != nil, "version", "must be provided")
+
+	if input.Activated != nil {
+		v.Check(*input.Activated, "activated", "must be true, disable the account instead")
+	}
+
+	if input.Disabled != nil && *input.Disabled {
+		v.Check(user.ID != application.contextGetUser(r).ID, "disabled", "you can't disable your own account")
+	}
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if *input.Version != user.Version {
+		application.editConflictResponse(w, r)
+		return
+	}
+
+	wasActivated := user.Activated
+	wasDisabled := user.IsDisabled()
+
+	if input.Activated != nil {
+		user.Activated = true
+	}
+
+	if input.Disabled != nil {
+		switch {
+		case *input.Disabled && !wasDisabled:
+			disabledAt := time.Now().Truncate(time.Second)
+			user.DisabledAt = &disabledAt
+		case !*input.Disabled:
+			user.DisabledAt = nil
+		}
+	}
+
+	disabling := user.IsDisabled() && !wasDisabled
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		if !disabling {
+			return nil
+		}
+
+		err = tx.Token.DeleteAllScopesForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.APIKeys.DeleteAllForUser(user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	if disabling {
+		err = application.revokeUserSignedTokens(user.ID)
+		if err != nil {
+			application.dataErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if user.Activated != wasActivated {
+		application.auditUser(r, data.AuditUserActivated, user.ID, map[string]string{"method": "admin"})
+	}
+
+	if user.IsDisabled() != wasDisabled {
+		action := data.AuditUserEnabled
+		if disabling {
+			action = data.AuditUserDisabled
+		}
+
+		application.auditUser(r, action, user.ID, nil)
+	}
+
+	account, err := application.newAccountResponse(user)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"user": account}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// forcePasswordResetHandler replaces the user's password with a random one nobody
+// knows, revokes all of their tokens and API keys and emails them a password reset
+// token.
+func (application *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := application.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Version *int `json:"version"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Version != nil, "version", "must be provided"); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if *input.Version != user.Version {
+		application.editConflictResponse(w, r)
+		return
+	}
+
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = user.Password.Set(base64.RawURLEncoding.EncodeToString(randomBytes))
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var token *data.Token
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Token.DeleteAllScopesForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.APIKeys.DeleteAllForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Token.New(user.ID, 24*time.Hour, data.ScopePasswordReset)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	err = application.revokeUserSignedTokens(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditPasswordChanged, user.ID, map[string]string{"method": "admin_reset"})
+
+	application.background(func() {
+		passwordResetInfo := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := application.mailer.Send(user.Email, "admin_password_reset.tmpl", passwordResetInfo)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "the user's password has been reset and they have been emailed instructions to choose a new one"}
+
+	err = application.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeUserTokensHandler signs the user out everywhere by revoking their tokens,
+// signed access tokens included, and their API keys.
+func (application *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := application.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := application.models.Transaction(func(tx data.Models) error {
+		err := tx.Token.DeleteAllScopesForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.APIKeys.DeleteAllForUser(user.ID)
+	})
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	err = application.revokeUserSignedTokens(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditTokenRevoked, user.ID, map[string]string{"reason": "admin_revoked"})
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "all of the user's tokens and API keys have been revoked"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam loads the user named by the :id route parameter. It writes the error
+// response itself and reports whether the handler can go on.
+func (application *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := application.readIDParam(r)
+	if err != nil {
+		application.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := application.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.notFoundResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateUserValidation(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	admin := insertTestAdmin(t, application, "update-user-admin@example.com")
+	user := insertTestUser(t, application, "update-user@example.com", false)
+
+	adminToken := bearer(signIn(t, routes, admin.Email, false).string("authentication_token", "token"))
+
+	tests := []struct {
+		name       string
+		userID     int64
+		body       map[string]any
+		wantStatus int
+	}{
+		{name: "missing version", userID: user.ID, body: map[string]any{"activated": true}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "stale version", userID: user.ID, body: map[string]any{"activated": true, "version": user.Version + 1}, wantStatus: http.StatusConflict},
+		{name: "deactivating", userID: user.ID, body: map[string]any{"activated": false, "version": user.Version}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "disabling yourself", userID: admin.ID, body: map[string]any{"disabled": true, "version": admin.Version}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "activating", userID: user.ID, body: map[string]any{"activated": true, "version": user.Version}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, routes, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", tt.userID), tt.body, adminToken)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestAdminRevokesAccess(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	admin := insertTestAdmin(t, application, "revoke-access-admin@example.com")
+	adminToken := bearer(signIn(t, routes, admin.Email, false).string("authentication_token", "token"))
+
+	tests := []struct {
+		name   string
+		revoke func(user *data.User) *testResponse
+		// wantSignIn is the status of signing in with the old password afterwards.
+		wantSignIn int
+	}{
+		{
+			name: "disable",
+			revoke: func(user *data.User) *testResponse {
+				return send(t, routes, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", user.ID),
+					map[string]any{"disabled": true, "version": user.Version}, adminToken)
+			},
+			wantSignIn: http.StatusForbidden,
+		},
+		{
+			name: "force password reset",
+			revoke: func(user *data.User) *testResponse {
+				return send(t, routes, http.MethodPost, fmt.Sprintf("/api/v1/admin/users/%d/password-reset", user.ID),
+					map[string]any{"version": user.Version}, adminToken)
+			},
+			wantSignIn: http.StatusUnauthorized,
+		},
+		{
+			name: "revoke tokens",
+			revoke: func(user *data.User) *testResponse {
+				return send(t, routes, http.MethodDelete, fmt.Sprintf("/api/v1/admin/users/%d/tokens", user.ID), nil, adminToken)
+			},
+			wantSignIn: http.StatusCreated,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := insertTestUser(t, application, fmt.Sprintf("revoke-access-%d@example.com", i), true)
+
+			err := application.models.Permissions.AddForUser(user.ID, "movies:read")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session := signIn(t, routes, user.Email, false)
+			key := insertTestAPIKey(t, application, user.ID, "movies:read")
+
+			magicLink, err := application.models.Token.New(user.ID, time.Hour, data.ScopeMagicLink)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := tt.revoke(user)
+			if res.StatusCode >= 300 {
+				t.Fatalf("got status %d (%v)", res.StatusCode, res.body)
+			}
+
+			if res := send(t, routes, http.MethodGet, "/users/me", nil, bearer(session.string("authentication_token", "token"))); res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("access token: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+			}
+
+			if res := send(t, routes, http.MethodGet, "/api/v1/movies", nil, apiKey(key)); res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("API key: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+			}
+
+			res = send(t, routes, http.MethodPost, "/tokens/refresh", map[string]any{"refresh_token": session.string("refresh_token", "token")}, nil)
+			if res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("refresh token: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+			}
+
+			if _, err := application.models.Token.Get(data.ScopeMagicLink, magicLink.Plaintext); err == nil {
+				t.Error("the magic link token survived")
+			}
+
+			res = send(t, routes, http.MethodPost, "/tokens/authentication", map[string]any{"email": user.Email, "password": testPassword}, nil)
+			if res.StatusCode != tt.wantSignIn {
+				t.Errorf("signing in: got status %d; want %d (%v)", res.StatusCode, tt.wantSignIn, res.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (application *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		AllowedIPs  []string   `json:"allowed_ips"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := application.contextGetUser(r)
+
+	permissions, err := application.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	key, err := data.GenerateAPIKey(user.ID)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key.Name = input.Name
+	key.Permissions = input.Permissions
+	key.Expiry = input.Expiry
+
+	if input.AllowedIPs != nil {
+		key.AllowedIPs = input.AllowedIPs
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = application.models.APIKeys.Insert(key)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditAPIKeyCreated, user.ID, map[string]string{"key": key.ID, "name": key.Name})
+
+	// This is the only time the key's plaintext is ever shown.
+	err = application.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	keys, err := application.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	err := application.models.APIKeys.Delete(user.ID, params.ByName("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.notFoundResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.auditUser(r, data.AuditAPIKeyRevoked, user.ID, map[string]string{"key": params.ByName("id")})
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "API key revoked successfully"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
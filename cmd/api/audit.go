@@ -0,0 +1,95 @@
+package main
+
+import (
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+)
+
+// audit records a security event for the request. The IP address, user agent and
+// request ID come from r, and the actor defaults to the signed in user. The event is
+// written in the background, so a failure to write it is logged and counted in the
+// audit_failures expvar rather than failing the request.
+func (application *application) audit(r *http.Request, event *data.AuditEvent) {
+	event.IP = application.clientIP(r)
+	event.UserAgent = truncate(r.UserAgent(), 512)
+	event.RequestID = application.contextGetRequestID(r)
+
+	if event.ActorID == nil {
+		if user, ok := r.Context().Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+			actorID := user.ID
+			event.ActorID = &actorID
+		}
+	}
+
+	application.background(func() {
+		err := application.models.AuditEvents.Insert(event)
+		if err != nil {
+			application.auditFailures.Add(1)
+			application.log.PrintError(err, map[string]string{
+				"audit_action": event.Action,
+				"request_id":   event.RequestID,
+			})
+		}
+	})
+}
+
+// auditUser is audit for an event about the user, who is also the actor unless someone
+// else is signed in.
+func (application *application) auditUser(r *http.Request, action string, userID int64, details map[string]string) {
+	event := &data.AuditEvent{
+		Action:    action,
+		SubjectID: &userID,
+		Details:   details,
+	}
+
+	if current, ok := r.Context().Value(userContextKey).(*data.User); !ok || current.IsAnonymous() {
+		event.ActorID = &userID
+	}
+
+	application.audit(r, event)
+}
+
+func (application *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var qsValues struct {
+		data.AuditEventFilter
+		data.FilterOptions
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	qsValues.Action = application.readStringValue(qs, "action", "")
+	qsValues.ActorID = application.readID(qs, "actor_id", v)
+	qsValues.SubjectID = application.readID(qs, "subject_id", v)
+	qsValues.IP = application.readStringValue(qs, "ip", "")
+	qsValues.RequestID = application.readStringValue(qs, "request_id", "")
+	qsValues.Since = application.readTime(qs, "since", v)
+	qsValues.Until = application.readTime(qs, "until", v)
+
+	qsValues.FilterOptions.Page = application.readInt(qs, "page", 1, v)
+	qsValues.FilterOptions.PageSize = application.readInt(qs, "page_size", 20, v)
+	qsValues.FilterOptions.Sort = application.readStringValue(qs, "sort", "-id")
+
+	qsValues.FilterOptions.SortableValues = []string{"id", "action", "created_at", "-id", "-action", "-created_at"}
+
+	if qsValues.Since != nil && qsValues.Until != nil {
+		v.Check(qsValues.Since.Before(*qsValues.Until), "until", "must be after since")
+	}
+
+	if data.ValidateFilters(v, qsValues.FilterOptions); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := application.models.AuditEvents.GetAll(qsValues.AuditEventFilter, qsValues.FilterOptions)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "audit_events": events}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/totp"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// auditEvents waits for the application's background work and returns the audit
+// events about or by the user.
+func auditEvents(t *testing.T, application *application, userID int64) []*data.AuditEvent {
+	t.Helper()
+
+	application.wg.Wait()
+
+	events, err := application.models.AuditEvents.GetAllForUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
+
+// strings returns the list of strings at key in the response body.
+func (res *testResponse) strings(key string) []string {
+	values, _ := res.body[key].([]any)
+
+	s := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+func TestAuditedAccountChanges(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	user := insertTestUser(t, application, "audited@example.com", true)
+	email := user.Email
+	newEmail := "audited-new@example.com"
+
+	token := bearer(signIn(t, routes, email, false).string("authentication_token", "token"))
+
+	var recoveryCodes []string
+
+	// The steps run in order against the one account.
+	tests := []struct {
+		name        string
+		do          func(t *testing.T) *testResponse
+		wantAction  string
+		wantDetails map[string]string
+	}{
+		{
+			name: "password reset requested",
+			do: func(t *testing.T) *testResponse {
+				return send(t, routes, http.MethodPost, "/tokens/password-reset", map[string]any{"email": email}, nil)
+			},
+			wantAction:  data.AuditTokenCreated,
+			wantDetails: map[string]string{"reason": "password_reset"},
+		},
+		{
+			name: "magic link requested",
+			do: func(t *testing.T) *testResponse {
+				return send(t, routes, http.MethodPost, "/tokens/magic-link", map[string]any{"email": email}, nil)
+			},
+			wantAction:  data.AuditTokenCreated,
+			wantDetails: map[string]string{"reason": "magic_link"},
+		},
+		{
+			name: "email change requested",
+			do: func(t *testing.T) *testResponse {
+				return send(t, routes, http.MethodPut, "/users/me/email", map[string]any{"email": newEmail, "password": testPassword}, token)
+			},
+			wantAction:  data.AuditEmailChangeRequested,
+			wantDetails: map[string]string{"email": newEmail},
+		},
+		{
+			name: "email changed",
+			do: func(t *testing.T) *testResponse {
+				application.wg.Wait()
+
+				sent := testMailer.sentTo(newEmail, "email_change_confirm.tmpl")
+				if len(sent) == 0 {
+					t.Fatal("no confirmation email")
+				}
+
+				info, _ := sent[len(sent)-1].data.(map[string]any)
+				changeToken, _ := info["emailChangeToken"].(string)
+
+				res := send(t, routes, http.MethodPut, "/users/email", map[string]any{"token": changeToken}, nil)
+				email = newEmail
+				return res
+			},
+			wantAction:  data.AuditEmailChanged,
+			wantDetails: map[string]string{"previous_email": "audited@example.com", "email": newEmail},
+		},
+		{
+			name: "two-factor enrolment started",
+			do: func(t *testing.T) *testResponse {
+				return send(t, routes, http.MethodPost, "/users/me/mfa", map[string]any{"password": testPassword}, token)
+			},
+			wantAction: data.AuditMFAEnrolled,
+		},
+		{
+			name: "two-factor authentication enabled",
+			do: func(t *testing.T) *testResponse {
+				mfa, err := application.models.MFA.Get(user.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				secret, err := application.mfaCipher.Decrypt(mfa.Secret, mfaAdditionalData(user.ID))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				code := totp.Code(secret, totp.Counter(time.Now()))
+
+				res := send(t, routes, http.MethodPut, "/users/me/mfa/confirm", map[string]any{"code": code}, token)
+				recoveryCodes = res.strings("recovery_codes")
+				return res
+			},
+			wantAction: data.AuditMFAEnabled,
+		},
+		{
+			name: "recovery codes regenerated",
+			do: func(t *testing.T) *testResponse {
+				res := send(t, routes, http.MethodPost, "/users/me/mfa/recovery-codes", map[string]any{"password": testPassword, "code": recoveryCodes[0]}, token)
+				recoveryCodes = res.strings("recovery_codes")
+				return res
+			},
+			wantAction: data.AuditRecoveryCodesRegenerated,
+		},
+		{
+			name: "two-factor authentication disabled",
+			do: func(t *testing.T) *testResponse {
+				return send(t, routes, http.MethodDelete, "/users/me/mfa", map[string]any{"password": testPassword, "code": recoveryCodes[0]}, token)
+			},
+			wantAction: data.AuditMFADisabled,
+		},
+		{
+			name: "deletion scheduled",
+			do: func(t *testing.T) *testResponse {
+				return send(t, routes, http.MethodDelete, "/users/me", map[string]any{"password": testPassword}, token)
+			},
+			wantAction: data.AuditDeletionScheduled,
+		},
+		{
+			name: "deletion canceled",
+			do: func(t *testing.T) *testResponse {
+				return signIn(t, routes, email, false)
+			},
+			wantAction: data.AuditDeletionCanceled,
+		},
+	}
+
+	// seen is how many of the user's events the earlier steps accounted for.
+	seen := len(auditEvents(t, application, user.ID))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := tt.do(t)
+			if res.StatusCode >= 300 {
+				t.Fatalf("got status %d (%v)", res.StatusCode, res.body)
+			}
+
+			events := auditEvents(t, application, user.ID)[seen:]
+			seen += len(events)
+
+			for _, event := range events {
+				if event.Action != tt.wantAction {
+					continue
+				}
+
+				for key, want := range tt.wantDetails {
+					if got := event.Details[key]; got != want {
+						t.Errorf("got %s %q; want %q", key, got, want)
+					}
+				}
+				return
+			}
+
+			t.Errorf("got %d new events, none of them %q", len(events), tt.wantAction)
+		})
+	}
+}
+
+// failingAuditEvents can't write audit events.
+type failingAuditEvents struct {
+	data.IAuditEventModel
+}
+
+func (failingAuditEvents) Insert(event *data.AuditEvent) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestAuditFailuresAreCounted(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	user := insertTestUser(t, application, "audit-failure@example.com", true)
+
+	models := application.models.AuditEvents
+	application.models.AuditEvents = failingAuditEvents{models}
+	t.Cleanup(func() { application.models.AuditEvents = models })
+
+	before := application.auditFailures.Value()
+
+	signIn(t, routes, user.Email, false)
+	application.wg.Wait()
+
+	if got := application.auditFailures.Value() - before; got != 1 {
+		t.Errorf("counted %d failures; want 1", got)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/sha256"
+	"expvar"
+	"github.com/lib/pq"
+	"greenlight.badrchoubai.dev/internal/data"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// authCacheChannel is the Postgres channel instances use to tell each other which
+	// cached users to drop. The payload is a user ID.
+	authCacheChannel = "auth_cache_invalidations"
+
+	// authCacheTouchInterval is how often a cached access token's last use is written
+	// to the database. Token.Touch only moves it forward once a minute anyway.
+	authCacheTouchInterval = time.Minute
+)
+
+type (
+	// authCache keeps the results of the lookups the auth middleware makes on every
+	// request: the user an access token belongs to and the user's permissions. Entries
+	// live for at most ttl and are dropped as soon as this or any other instance
+	// changes the user, their tokens or their permissions.
+	authCache struct {
+		ttl        time.Duration
+		maxEntries int
+
+		mu sync.Mutex
+		// generation changes with every invalidation. A lookup that started before
+		// one doesn't store its result, since it may have read what was invalidated.
+		generation  uint64
+		users       map[[sha256.Size]byte]authCacheEntry[*data.User]
+		permissions map[int64]authCacheEntry[data.Permissions]
+
+		stats *expvar.Map
+	}
+
+	authCacheEntry[V any] struct {
+		value  V
+		userID int64
+		expiry time.Time
+		// touched is when the entry's token was last touched, for user entries.
+		touched time.Time
+	}
+)
+
+func newAuthCache(ttl time.Duration, maxEntries int) *authCache {
+	return &authCache{
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		users:       make(map[[sha256.Size]byte]authCacheEntry[*data.User]),
+		permissions: make(map[int64]authCacheEntry[data.Permissions]),
+		stats:       expvar.NewMap("auth_cache"),
+	}
+}
+
+// userForToken returns the user the access token belongs to, calling lookup on a miss.
+// A nil cache always calls lookup. The user is a copy, so handlers can change it freely.
+func (c *authCache) userForToken(token string, lookup func() (*data.User, error)) (*data.User, error) {
+	if c == nil {
+		return lookup()
+	}
+
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	entry, found := c.users[key]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if found && time.Now().Before(entry.expiry) {
+		c.stats.Add("token_hits", 1)
+		user := *entry.value
+		return &user, nil
+	}
+
+	c.stats.Add("token_misses", 1)
+
+	user, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	cached := *user
+
+	c.mu.Lock()
+	if generation == c.generation {
+		storeAuthCacheEntry(c, c.users, key, authCacheEntry[*data.User]{value: &cached, userID: user.ID})
+	}
+	c.mu.Unlock()
+
+	return user, nil
+}
+
+// permissionsForUser is userForToken for the user's permissions.
+func (c *authCache) permissionsForUser(userID int64, lookup func() (data.Permissions, error)) (data.Permissions, error) {
+	if c == nil {
+		return lookup()
+	}
+
+	c.mu.Lock()
+	entry, found := c.permissions[userID]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if found && time.Now().Before(entry.expiry) {
+		c.stats.Add("permission_hits", 1)
+		return append(data.Permissions(nil), entry.value...), nil
+	}
+
+	c.stats.Add("permission_misses", 1)
+
+	permissions, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if generation == c.generation {
+		cached := append(data.Permissions(nil), permissions...)
+		storeAuthCacheEntry(c, c.permissions, userID, authCacheEntry[data.Permissions]{value: cached, userID: userID})
+	}
+	c.mu.Unlock()
+
+	return permissions, nil
+}
+
+// storeAuthCacheEntry adds an entry to one of the cache's maps, making room first if it
+// is full: expired entries go, and if that isn't enough, arbitrary ones. The caller must
+// hold c.mu.
+func storeAuthCacheEntry[K comparable, V any](c *authCache, entries map[K]authCacheEntry[V], key K, entry authCacheEntry[V]) {
+	now := time.Now()
+
+	if _, found := entries[key]; !found && len(entries) >= c.maxEntries {
+		for k, e := range entries {
+			if !now.Before(e.expiry) {
+				delete(entries, k)
+			}
+		}
+
+		for k := range entries {
+			if len(entries) < c.maxEntries {
+				break
+			}
+			delete(entries, k)
+		}
+	}
+
+	// Replacing an expired entry keeps when it was last touched, so re-caching a token
+	// doesn't make touchDue write it again straight away.
+	if old, found := entries[key]; found {
+		entry.touched = old.touched
+	}
+
+	entry.expiry = now.Add(c.ttl)
+	entries[key] = entry
+}
+
+// touchDue reports whether the access token's last use should be written to the
+// database, which happens at most once every authCacheTouchInterval while the token is
+// cached. A nil cache, or a token that isn't cached, always reports true.
+func (c *authCache) touchDue(token string) bool {
+	if c == nil {
+		return true
+	}
+
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.users[key]
+	if !found {
+		return true
+	}
+
+	if now.Sub(entry.touched) < authCacheTouchInterval {
+		return false
+	}
+
+	entry.touched = now
+	c.users[key] = entry
+
+	return true
+}
+
+// invalidateUser drops everything cached for the user.
+func (c *authCache) invalidateUser(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for key, entry := range c.users {
+		if entry.userID == userID {
+			delete(c.users, key)
+		}
+	}
+	delete(c.permissions, userID)
+}
+
+func (c *authCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	c.users = make(map[[sha256.Size]byte]authCacheEntry[*data.User])
+	c.permissions = make(map[int64]authCacheEntry[data.Permissions])
+}
+
+// invalidateAuthCache drops the user's cached lookups, here and on every other instance.
+// Call it once a change to the user, their tokens or their permissions has committed.
+func (application *application) invalidateAuthCache(userID int64) {
+	if application.authCache == nil {
+		return
+	}
+
+	application.authCache.invalidateUser(userID)
+
+	err := application.models.Notifications.Notify(authCacheChannel, strconv.FormatInt(userID, 10))
+	if err != nil {
+		application.log.PrintError(err, nil)
+	}
+}
+
+// listenForAuthCacheInvalidations applies the invalidations other instances publish.
+// Notifications sent while the connection was down are lost, so the whole cache is
+// dropped whenever it is re-established.
+func (application *application) listenForAuthCacheInvalidations() error {
+	listener := pq.NewListener(application.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			application.log.PrintError(err, map[string]string{"listener": authCacheChannel})
+		}
+	})
+
+	err := listener.Listen(authCacheChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	application.wg.Add(1)
+	go func() {
+		defer application.wg.Done()
+		defer listener.Close()
+
+		for {
+			select {
+			case <-application.shutdown:
+				return
+			case notification := <-listener.Notify:
+				// A nil notification means the connection was re-established.
+				if notification == nil {
+					application.authCache.clear()
+					continue
+				}
+
+				userID, err := strconv.ParseInt(notification.Extra, 10, 64)
+				if err != nil {
+					application.log.PrintError(err, map[string]string{"listener": authCacheChannel})
+					continue
+				}
+
+				application.authCache.invalidateUser(userID)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"expvar"
+	"greenlight.badrchoubai.dev/internal/data"
+	"testing"
+	"time"
+)
+
+// newTestAuthCache is newAuthCache without the published expvar, which can only be
+// registered once per process.
+func newTestAuthCache(ttl time.Duration, maxEntries int) *authCache {
+	return &authCache{
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		users:       make(map[[sha256.Size]byte]authCacheEntry[*data.User]),
+		permissions: make(map[int64]authCacheEntry[data.Permissions]),
+		stats:       new(expvar.Map).Init(),
+	}
+}
+
+// countingLookup returns a user lookup that counts its calls.
+func countingLookup(userID int64, calls *int) func() (*data.User, error) {
+	return func() (*data.User, error) {
+		*calls++
+		return &data.User{ID: userID, Name: "alice"}, nil
+	}
+}
+
+func TestAuthCacheUserForToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// between runs after the first lookup and before the second.
+		between   func(c *authCache)
+		wantCalls int
+	}{
+		{name: "hit", between: func(c *authCache) {}, wantCalls: 1},
+		{name: "invalidated user", between: func(c *authCache) { c.invalidateUser(1) }, wantCalls: 2},
+		{name: "other user invalidated", between: func(c *authCache) { c.invalidateUser(2) }, wantCalls: 1},
+		{name: "cleared", between: func(c *authCache) { c.clear() }, wantCalls: 2},
+		{
+			name: "expired",
+			between: func(c *authCache) {
+				key := sha256.Sum256([]byte("token"))
+				entry := c.users[key]
+				entry.expiry = time.Now().Add(-time.Second)
+				c.users[key] = entry
+			},
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestAuthCache(time.Minute, 10)
+			calls := 0
+
+			_, err := c.userForToken("token", countingLookup(1, &calls))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.between(c)
+
+			user, err := c.userForToken("token", countingLookup(1, &calls))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if calls != tt.wantCalls {
+				t.Errorf("lookup called %d times; want %d", calls, tt.wantCalls)
+			}
+			if user.ID != 1 {
+				t.Errorf("got user %d; want 1", user.ID)
+			}
+		})
+	}
+}
+
+func TestAuthCacheReturnsCopies(t *testing.T) {
+	c := newTestAuthCache(time.Minute, 10)
+	calls := 0
+
+	user, _ := c.userForToken("token", countingLookup(1, &calls))
+	user.Name = "changed by the caller"
+
+	cached, _ := c.userForToken("token", countingLookup(1, &calls))
+	if cached.Name != "alice" {
+		t.Errorf("a caller's change leaked into the cache: got %q", cached.Name)
+	}
+
+	c.permissionsForUser(1, func() (data.Permissions, error) { return data.Permissions{"movies:read"}, nil })
+	permissions, _ := c.permissionsForUser(1, nil)
+	permissions[0] = "movies:write"
+
+	permissions, _ = c.permissionsForUser(1, nil)
+	if permissions[0] != "movies:read" {
+		t.Errorf("a caller's change leaked into the cache: got %q", permissions[0])
+	}
+}
+
+func TestAuthCacheGeneration(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(c *authCache)
+	}{
+		{name: "user invalidated", invalidate: func(c *authCache) { c.invalidateUser(1) }},
+		{name: "other user invalidated", invalidate: func(c *authCache) { c.invalidateUser(2) }},
+		{name: "cleared", invalidate: func(c *authCache) { c.clear() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestAuthCache(time.Minute, 10)
+
+			// An invalidation that lands while a lookup is in flight means the lookup
+			// may have read stale rows, so its result must not be cached.
+			_, err := c.userForToken("token", func() (*data.User, error) {
+				tt.invalidate(c)
+				return &data.User{ID: 1}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.permissionsForUser(1, func() (data.Permissions, error) {
+				tt.invalidate(c)
+				return data.Permissions{"movies:read"}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(c.users) != 0 || len(c.permissions) != 0 {
+				t.Errorf("stale lookups were cached: %d users, %d permissions", len(c.users), len(c.permissions))
+			}
+		})
+	}
+}
+
+func TestAuthCacheLookupErrorsAreNotCached(t *testing.T) {
+	c := newTestAuthCache(time.Minute, 10)
+	errLookup := errors.New("lookup failed")
+
+	_, err := c.userForToken("token", func() (*data.User, error) { return nil, errLookup })
+	if !errors.Is(err, errLookup) {
+		t.Fatalf("got %v; want the lookup's error", err)
+	}
+
+	if len(c.users) != 0 {
+		t.Error("a failed lookup was cached")
+	}
+}
+
+func TestAuthCacheMaxEntries(t *testing.T) {
+	c := newTestAuthCache(time.Minute, 2)
+	calls := 0
+
+	for _, token := range []string{"a", "b", "c", "d"} {
+		_, err := c.userForToken(token, countingLookup(1, &calls))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(c.users) > 2 {
+			t.Fatalf("cache holds %d users; want at most 2", len(c.users))
+		}
+	}
+
+	// The newest entry always survives eviction.
+	if _, found := c.users[sha256.Sum256([]byte("d"))]; !found {
+		t.Error("the entry just stored was evicted")
+	}
+
+	// Expired entries are evicted before live ones.
+	c = newTestAuthCache(time.Minute, 2)
+	c.userForToken("expired", countingLookup(1, &calls))
+	c.userForToken("live", countingLookup(1, &calls))
+
+	key := sha256.Sum256([]byte("expired"))
+	entry := c.users[key]
+	entry.expiry = time.Now().Add(-time.Second)
+	c.users[key] = entry
+
+	c.userForToken("new", countingLookup(1, &calls))
+
+	if _, found := c.users[sha256.Sum256([]byte("live"))]; !found {
+		t.Error("a live entry was evicted while an expired one was there")
+	}
+}
+
+func TestAuthCacheTouchDue(t *testing.T) {
+	c := newTestAuthCache(time.Minute, 10)
+	calls := 0
+
+	if !c.touchDue("token") {
+		t.Error("touchDue = false for a token that isn't cached")
+	}
+
+	c.userForToken("token", countingLookup(1, &calls))
+
+	steps := []struct {
+		name   string
+		before func()
+		want   bool
+	}{
+		{name: "first use", before: func() {}, want: true},
+		{name: "straight after", before: func() {}, want: false},
+		{
+			name: "interval passed",
+			before: func() {
+				key := sha256.Sum256([]byte("token"))
+				entry := c.users[key]
+				entry.touched = time.Now().Add(-authCacheTouchInterval)
+				c.users[key] = entry
+			},
+			want: true,
+		},
+		{
+			// Re-caching the token after it expires keeps when it was touched.
+			name: "re-cached",
+			before: func() {
+				key := sha256.Sum256([]byte("token"))
+				entry := c.users[key]
+				entry.expiry = time.Now().Add(-time.Second)
+				c.users[key] = entry
+				c.userForToken("token", countingLookup(1, &calls))
+			},
+			want: false,
+		},
+		{name: "invalidated", before: func() { c.invalidateUser(1) }, want: true},
+	}
+
+	for _, step := range steps {
+		step.before()
+		if got := c.touchDue("token"); got != step.want {
+			t.Errorf("%s: touchDue = %t; want %t", step.name, got, step.want)
+		}
+	}
+}
+
+func TestNilAuthCache(t *testing.T) {
+	var c *authCache
+	calls := 0
+
+	for i := 0; i < 2; i++ {
+		_, err := c.userForToken("token", countingLookup(1, &calls))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.permissionsForUser(1, func() (data.Permissions, error) {
+			calls++
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 4 {
+		t.Errorf("lookups called %d times; want 4", calls)
+	}
+
+	if !c.touchDue("token") {
+		t.Error("touchDue = false for a nil cache")
+	}
+
+	c.invalidateUser(1)
+	c.clear()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses space separated IP addresses and CIDR ranges.
+func parseTrustedProxies(specs string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, spec := range strings.Fields(specs) {
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", spec)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q", spec)
+		}
+
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// isTrustedProxy reports whether ip belongs to one of -trusted-proxies.
+func (application *application) isTrustedProxy(ip net.IP) bool {
+	for _, network := range application.config.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP returns the address of the client that made the request. Anyone can set
+// X-Forwarded-For and X-Real-IP, so they are only believed when the connection comes
+// from one of -trusted-proxies. X-Forwarded-For is read from the right, skipping the
+// trusted proxies that appended to it, which leaves the address the outermost of them
+// saw; anything further left was written by the client.
+func (application *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !application.isTrustedProxy(ip) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+
+			ip = hop
+			if !application.isTrustedProxy(ip) {
+				break
+			}
+		}
+
+		return ip.String()
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+
+	return ip.String()
+}
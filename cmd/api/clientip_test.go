@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.1 172.16.0.0/12 ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	application := &application{config: config{trustedProxies: proxies}}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.1:1234", want: "203.0.113.1"},
+		{name: "untrusted forwarded for", remoteAddr: "203.0.113.1:1234", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.1"},
+		{name: "untrusted real IP", remoteAddr: "203.0.113.1:1234", realIP: "198.51.100.1", want: "203.0.113.1"},
+		{name: "trusted forwarded for", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted real IP", remoteAddr: "10.0.0.1:1234", realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted range", remoteAddr: "172.20.1.1:1234", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted IPv6", remoteAddr: "[::1]:1234", forwardedFor: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "forged entries", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1, 198.51.100.1", "172.16.0.5"}, want: "198.51.100.1"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"172.16.0.5"}, want: "172.16.0.5"},
+		{name: "garbage", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1, garbage"}, want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := application.clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, specs := range []string{"not-an-ip", "10.0.0.0/33", "10.0.0.1 garbage/8"} {
+		if _, err := parseTrustedProxies(specs); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded", specs)
+		}
+	}
+}
@@ -3,12 +3,21 @@ package main
 import (
 	"context"
 	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/signedtoken"
 	"net/http"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	claimsContextKey = contextKey("claims")
+	apiKeyContextKey = contextKey("apiKey")
+
+	permissionsContextKey = contextKey("permissions")
+	requestIDContextKey   = contextKey("requestID")
+)
 
 func (application *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +32,68 @@ func (application *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetToken stores the plaintext of the authentication token the request was
+// made with, so handlers can act on the current session.
+func (application *application) contextSetToken(r *http.Request, tokenPlaintext string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, tokenPlaintext)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the plaintext of the request's authentication token, or an
+// empty string for anonymous requests.
+func (application *application) contextGetToken(r *http.Request) string {
+	tokenPlaintext, _ := r.Context().Value(tokenContextKey).(string)
+	return tokenPlaintext
+}
+
+// contextSetClaims stores the claims of the signed access token the request was made
+// with.
+func (application *application) contextSetClaims(r *http.Request, claims *signedtoken.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims returns the claims of the request's signed access token, or nil when
+// the request was made with an opaque token or anonymously.
+func (application *application) contextGetClaims(r *http.Request) *signedtoken.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*signedtoken.Claims)
+	return claims
+}
+
+func (application *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was made with, or nil when it was
+// made with a token or anonymously.
+func (application *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetPermissions stores the permissions requirePermission found the user to hold,
+// so policies evaluated later in the request don't look them up again.
+func (application *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the user's permissions, or nil when the request didn't
+// pass through requirePermission.
+func (application *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, _ := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions
+}
+
+func (application *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID the requestID middleware gave the request.
+func (application *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"greenlight.badrchoubai.dev/internal/data"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (application *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
@@ -12,6 +17,7 @@ func (application *application) authenticationRequiredResponse(w http.ResponseWr
 
 func (application *application) logError(r *http.Request, err error) {
 	application.log.PrintError(err, map[string]string{
+		"request_id":     application.contextGetRequestID(r),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -36,6 +42,29 @@ func (application *application) editConflictResponse(w http.ResponseWriter, r *h
 	application.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// dataErrorResponse sends the response matching a classified error from the data
+// layer, falling back to serverErrorResponse for anything unexpected.
+func (application *application) dataErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrUniqueViolation):
+		message := "a record with the same unique values already exists"
+		application.errorResponse(w, r, http.StatusConflict, message)
+	case errors.Is(err, data.ErrSerializationFailure):
+		message := "unable to complete the request due to a concurrent update, please try again"
+		application.errorResponse(w, r, http.StatusConflict, message)
+	case errors.Is(err, data.ErrCheckViolation):
+		message := "the request contains values that are not permitted"
+		application.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+	case errors.Is(err, data.ErrForeignKeyViolation):
+		message := "the request references a related record that does not exist"
+		application.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+	case errors.Is(err, data.ErrQueryTimeout):
+		application.serviceUnavailableResponse(w, r, err)
+	default:
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
 func (application *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	application.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -52,11 +81,42 @@ func (application *application) serverErrorResponse(w http.ResponseWriter, r *ht
 	application.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+func (application *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	application.logError(r, err)
+
+	w.Header().Set("Retry-After", "5")
+
+	message := "the server is temporarily unable to handle the request, please try again later"
+	application.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (application *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
 	application.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (application *application) disabledAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been disabled"
+	application.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (application *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	application.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (application *application) apiKeyAddressNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this API key can't be used from your IP address"
+	application.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (application *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+
+	message := "invalid or expired API key"
+	application.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (application *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
@@ -64,11 +124,23 @@ func (application *application) invalidAuthenticationTokenResponse(w http.Respon
 	application.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (application *application) invalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "missing or invalid CSRF token"
+	application.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (application *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	application.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (application *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed sign in attempts, please try again later"
+	application.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (application *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("Method: '%s', is not supported for this request", r.Method)
 	application.errorResponse(w, r, http.StatusMethodNotAllowed, message)
@@ -79,7 +151,17 @@ func (application *application) notFoundResponse(w http.ResponseWriter, r *http.
 	application.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+func (application *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "registration is closed"
+	application.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (application *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	application.audit(r, &data.AuditEvent{
+		Action:  data.AuditAccessDenied,
+		Details: map[string]string{"method": r.Method, "path": r.URL.Path},
+	})
+
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	application.errorResponse(w, r, http.StatusForbidden, message)
 }
@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Define an envelope type
@@ -78,6 +80,73 @@ func (application *application) readInt(qs url.Values, key string, defaultValue
 	return i
 }
 
+// readBool returns nil when key is absent, so callers can tell "not given" apart from
+// false.
+func (application *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readID reads a positive record ID from the query string, recording a validation error
+// if it is malformed. It returns nil if the key is absent or invalid.
+func (application *application) readID(qs url.Values, key string, v *validator.Validator) *int64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 1 {
+		v.AddError(key, "must be a positive integer")
+		return nil
+	}
+
+	return &id
+}
+
+// readTime reads an RFC 3339 timestamp from the query string, recording a validation
+// error if it is malformed. It returns nil if the key is absent or invalid.
+func (application *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+
+	return &t
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
 func (application *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	JSON, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// createInvitationHandler emails an invitation to register, replacing any earlier one
+// sent to the same address. Invitations work in every registration mode but "closed".
+func (application *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if application.config.account.registrationMode == "closed" {
+		application.registrationClosedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email       string           `json:"email"`
+		Permissions data.Permissions `json:"permissions"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := application.models.Permissions.GetAll()
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+
+	if data.ValidatePermissionCodes(v, "permissions", input.Permissions, known); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = application.models.Users.GetByEmail(input.Email)
+	if err == nil {
+		v.AddError("email", "a user with this email already exists")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	} else if !errors.Is(err, data.ErrRecordNotFound) {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	userID := application.contextGetUser(r).ID
+
+	invitation, err := data.GenerateInvitation(input.Email, input.Permissions, &userID, application.config.account.invitationTTL)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Invitations.DeleteAllForEmail(invitation.Email)
+		if err != nil {
+			return err
+		}
+
+		return tx.Invitations.Insert(invitation)
+	})
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.audit(r, &data.AuditEvent{
+		Action:  data.AuditInvitationCreated,
+		Details: map[string]string{"email": invitation.Email, "permissions": strings.Join(invitation.Permissions, ",")},
+	})
+
+	application.background(func() {
+		invitationInfo := map[string]any{
+			"email":           invitation.Email,
+			"invitationToken": invitation.Plaintext,
+			"expiry":          invitation.Expiry.UTC().Format(time.RFC1123),
+		}
+
+		err := application.mailer.Send(invitation.Email, "user_invitation.tmpl", invitationInfo)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	err = application.writeJSON(w, http.StatusAccepted, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+	"testing"
+)
+
+// useRegistrationMode changes the registration mode for the length of a test.
+func useRegistrationMode(t *testing.T, application *application, mode string) {
+	t.Helper()
+
+	previous := application.config.account.registrationMode
+	application.config.account.registrationMode = mode
+	t.Cleanup(func() { application.config.account.registrationMode = previous })
+}
+
+func TestCreateInvitation(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	admin := insertTestAdmin(t, application, "invitation-admin@example.com")
+	adminToken := bearer(signIn(t, routes, admin.Email, false).string("authentication_token", "token"))
+
+	tests := []struct {
+		name       string
+		mode       string
+		email      string
+		wantStatus int
+	}{
+		{name: "open", mode: "open", email: "invited-open@example.com", wantStatus: http.StatusAccepted},
+		{name: "invite", mode: "invite", email: "invited-invite@example.com", wantStatus: http.StatusAccepted},
+		{name: "closed", mode: "closed", email: "invited-closed@example.com", wantStatus: http.StatusForbidden},
+		{name: "existing user", mode: "invite", email: admin.Email, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRegistrationMode(t, application, tt.mode)
+
+			res := send(t, routes, http.MethodPost, "/api/v1/admin/invitations", map[string]any{"email": tt.email}, adminToken)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			application.wg.Wait()
+
+			sent := len(testMailer.sentTo(tt.email, "user_invitation.tmpl")) > 0
+			if want := tt.wantStatus == http.StatusAccepted; sent != want {
+				t.Errorf("invitation emailed: %t; want %t", sent, want)
+			}
+		})
+	}
+}
+
+func TestRegisterWithInvitation(t *testing.T) {
+	application, routes := newTestApplication(t)
+	useRegistrationMode(t, application, "invite")
+
+	const email = "invitee@example.com"
+
+	invitation, err := data.GenerateInvitation(email, data.Permissions{"movies:write"}, nil, application.config.account.invitationTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = application.models.Invitations.Insert(invitation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	register := func(email, password, token string) map[string]any {
+		return map[string]any{"name": "Invitee", "email": email, "password": password, "invitation": token}
+	}
+
+	// The cases run in order: the invitation survives every refused registration and
+	// is used up by the first good one.
+	tests := []struct {
+		name       string
+		mode       string
+		body       map[string]any
+		wantStatus int
+		wantError  string
+	}{
+		{name: "closed", mode: "closed", body: register(email, testPassword, invitation.Plaintext), wantStatus: http.StatusForbidden},
+		{name: "no invitation", mode: "invite", body: register(email, testPassword, ""), wantStatus: http.StatusUnprocessableEntity, wantError: "invitation"},
+		{name: "unknown invitation", mode: "invite", body: register(email, testPassword, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"), wantStatus: http.StatusUnprocessableEntity, wantError: "invitation"},
+		{name: "another address", mode: "invite", body: register("uninvited@example.com", testPassword, invitation.Plaintext), wantStatus: http.StatusUnprocessableEntity, wantError: "email"},
+		{name: "short password", mode: "invite", body: register(email, "short", invitation.Plaintext), wantStatus: http.StatusUnprocessableEntity, wantError: "password"},
+		{name: "accepted", mode: "invite", body: register(email, testPassword, invitation.Plaintext), wantStatus: http.StatusCreated},
+		{name: "used invitation", mode: "open", body: register("second-"+email, testPassword, invitation.Plaintext), wantStatus: http.StatusUnprocessableEntity, wantError: "invitation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRegistrationMode(t, application, tt.mode)
+
+			res := send(t, routes, http.MethodPost, "/users", tt.body, nil)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			if tt.wantError != "" && res.string("error", tt.wantError) == "" {
+				t.Errorf("got %v; want an error for %q", res.body, tt.wantError)
+			}
+		})
+	}
+
+	user, err := application.models.Users.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.Activated {
+		t.Error("an invited user wasn't activated")
+	}
+
+	permissions, err := application.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !permissions.Include("movies:write") {
+		t.Errorf("got permissions %v; want the invitation's", permissions)
+	}
+}
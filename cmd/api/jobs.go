@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"greenlight.badrchoubai.dev/internal/data"
+	"time"
+)
+
+// tokenSweepLock names the advisory lock that stops instances sharing a database from
+// sweeping expired tokens at the same time.
+const tokenSweepLock = "greenlight:sweep_expired_tokens"
+
+// runPeriodically calls job every interval until the server starts shutting down. Like
+// background tasks, jobs are tracked by application.wg so shutdown waits for a run that
+// is in progress to finish.
+func (application *application) runPeriodically(name string, interval time.Duration, job func() error) {
+	application.wg.Add(1)
+	go func() {
+		defer application.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-application.shutdown:
+				return
+			case <-ticker.C:
+				application.runJob(name, job)
+			}
+		}
+	}()
+}
+
+func (application *application) runJob(name string, job func() error) {
+	defer func() {
+		if err := recover(); err != nil {
+			application.log.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+		}
+	}()
+
+	err := job()
+	if err != nil {
+		application.log.PrintError(err, map[string]string{"job": name})
+	}
+}
+
+func (application *application) purgeDeletedUsers() error {
+	deleted, err := application.models.Users.DeleteScheduled(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		application.log.PrintInfo("purged deleted user accounts", map[string]string{
+			"count": fmt.Sprint(deleted),
+		})
+	}
+
+	return nil
+}
+
+// sweepExpiredTokens deletes expired tokens and expired signed token revocations in
+// batches, each in its own short transaction. The run holds tokenSweepLock from start to
+// finish; if another instance holds it, that instance is already sweeping and this run
+// does nothing. The token_sweeps expvar map counts the runs, the runs skipped for that
+// reason, the tokens deleted and the revocations deleted.
+func (application *application) sweepExpiredTokens() error {
+	batchSize := application.config.auth.sweepBatchSize
+
+	application.tokenSweeps.Add("runs", 1)
+
+	unlock, acquired, err := application.models.Locks.TryLock(tokenSweepLock)
+	if err != nil {
+		return err
+	}
+
+	if !acquired {
+		application.tokenSweeps.Add("skipped", 1)
+		return nil
+	}
+
+	var total, totalRevocations int64
+
+	defer func() {
+		if err := unlock(); err != nil {
+			application.log.PrintError(err, map[string]string{"lock": tokenSweepLock})
+		}
+
+		if total > 0 || totalRevocations > 0 {
+			application.log.PrintInfo("swept expired tokens", map[string]string{
+				"count":       fmt.Sprint(total),
+				"revocations": fmt.Sprint(totalRevocations),
+			})
+		}
+	}()
+
+	for {
+		var deleted, revocations int64
+
+		err = application.models.Transaction(func(tx data.Models) error {
+			var err error
+
+			deleted, err = tx.Token.DeleteExpired(batchSize)
+			if err != nil {
+				return err
+			}
+
+			revocations, err = tx.RevokedTokens.DeleteExpired(batchSize)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		total += deleted
+		totalRevocations += revocations
+		application.tokenSweeps.Add("deleted", deleted)
+		application.tokenSweeps.Add("revocations_deleted", revocations)
+
+		if deleted < int64(batchSize) && revocations < int64(batchSize) {
+			return nil
+		}
+
+		// Stop between batches when shutting down; the next run picks up the rest.
+		select {
+		case <-application.shutdown:
+			return nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"expvar"
+	"greenlight.badrchoubai.dev/internal/data"
+	"testing"
+	"time"
+)
+
+// sweepCount returns the token_sweeps counter called key.
+func sweepCount(application *application, key string) int64 {
+	count, _ := application.tokenSweeps.Get(key).(*expvar.Int)
+	if count == nil {
+		return 0
+	}
+	return count.Value()
+}
+
+// useSweepBatchSize changes -auth-token-sweep-batch-size for the length of a test.
+func useSweepBatchSize(t *testing.T, application *application, size int) {
+	t.Helper()
+
+	previous := application.config.auth.sweepBatchSize
+	application.config.auth.sweepBatchSize = size
+	t.Cleanup(func() { application.config.auth.sweepBatchSize = previous })
+}
+
+// insertExpiredTokens adds n expired tokens and n expired revocations.
+func insertExpiredTokens(t *testing.T, application *application, userID int64, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := application.models.Token.New(userID, -time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := data.GenerateToken(userID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = application.models.RevokedTokens.Insert(token.Plaintext, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSweepExpiredTokens(t *testing.T) {
+	application, _ := newTestApplication(t)
+
+	useSweepBatchSize(t, application, 2)
+
+	user := insertTestUser(t, application, "swept@example.com", true)
+
+	live, err := application.models.Token.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertExpiredTokens(t, application, user.ID, 5)
+
+	runs, deleted, revocations := sweepCount(application, "runs"), sweepCount(application, "deleted"), sweepCount(application, "revocations_deleted")
+
+	// Five of each takes three batches of two, all in the one run.
+	err = application.sweepExpiredTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sweepCount(application, "runs") - runs; got != 1 {
+		t.Errorf("counted %d runs; want 1", got)
+	}
+
+	if got := sweepCount(application, "deleted") - deleted; got < 5 {
+		t.Errorf("deleted %d tokens; want at least 5", got)
+	}
+
+	if got := sweepCount(application, "revocations_deleted") - revocations; got < 5 {
+		t.Errorf("deleted %d revocations; want at least 5", got)
+	}
+
+	_, err = application.models.Token.Get(data.ScopeAuthentication, live.Plaintext)
+	if err != nil {
+		t.Errorf("the unexpired token is gone: %v", err)
+	}
+
+	// A second run finds nothing left to delete.
+	deleted = sweepCount(application, "deleted")
+
+	err = application.sweepExpiredTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sweepCount(application, "deleted") - deleted; got != 0 {
+		t.Errorf("deleted %d tokens on the second run; want 0", got)
+	}
+}
+
+func TestSweepSkippedWhileLocked(t *testing.T) {
+	application, _ := newTestApplication(t)
+	useSweepBatchSize(t, application, 100)
+
+	user := insertTestUser(t, application, "sweep-locked@example.com", true)
+	insertExpiredTokens(t, application, user.ID, 1)
+
+	// Another instance is sweeping.
+	unlock, acquired, err := application.models.Locks.TryLock(tokenSweepLock)
+	if err != nil || !acquired {
+		t.Fatalf("couldn't take the lock: %t, %v", acquired, err)
+	}
+
+	skipped, deleted := sweepCount(application, "skipped"), sweepCount(application, "deleted")
+
+	err = application.sweepExpiredTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sweepCount(application, "skipped") - skipped; got != 1 {
+		t.Errorf("counted %d skipped runs; want 1", got)
+	}
+
+	if got := sweepCount(application, "deleted") - deleted; got != 0 {
+		t.Errorf("deleted %d tokens without the lock; want 0", got)
+	}
+
+	err = unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the lock is free the next run sweeps, and gives the lock back afterwards.
+	err = application.sweepExpiredTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sweepCount(application, "deleted") - deleted; got < 1 {
+		t.Errorf("deleted %d tokens after the lock was released; want at least 1", got)
+	}
+
+	unlock, acquired, err = application.models.Locks.TryLock(tokenSweepLock)
+	if err != nil || !acquired {
+		t.Fatalf("the sweep kept the lock: %t, %v", acquired, err)
+	}
+	unlock()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// baseLoginDelay is the delay added once an email address reaches -login-delay-after
+// failures. It doubles with every further failure, up to -login-max-delay.
+const baseLoginDelay = 500 * time.Millisecond
+
+type loginThrottle struct {
+	lockedUntil time.Time
+	delay       time.Duration
+}
+
+// loginThrottle works out whether a sign in attempt for the email address from the IP
+// address may go ahead, and how long it should be held up first. Failures are counted
+// over -login-window; once either count reaches its lockout threshold, attempts are
+// refused until -login-lockout-duration after the most recent failure.
+func (application *application) loginThrottle(email, ip string) (loginThrottle, error) {
+	cfg := application.config.login
+	since := time.Now().Add(-cfg.window)
+
+	var throttle loginThrottle
+
+	emailFailures, err := application.models.LoginAttempts.FailuresForEmail(email, since)
+	if err != nil {
+		return throttle, err
+	}
+
+	ipFailures, err := application.models.LoginAttempts.FailuresForIP(ip, since)
+	if err != nil {
+		return throttle, err
+	}
+
+	if emailFailures.Count >= cfg.lockoutThreshold {
+		throttle.lockedUntil = emailFailures.Last.Add(cfg.lockoutDuration)
+	}
+
+	if ipFailures.Count >= cfg.ipLockoutThreshold {
+		if until := ipFailures.Last.Add(cfg.lockoutDuration); until.After(throttle.lockedUntil) {
+			throttle.lockedUntil = until
+		}
+	}
+
+	if excess := emailFailures.Count - cfg.delayAfter; excess >= 0 {
+		throttle.delay = cfg.maxDelay
+		if excess < 16 && baseLoginDelay<<excess < cfg.maxDelay {
+			throttle.delay = baseLoginDelay << excess
+		}
+	}
+
+	return throttle, nil
+}
+
+// loginFailed records a failed sign in attempt and responds to it. When the failure
+// starts a lockout, the lockout is logged and, if the email address belongs to an
+// account, its owner is warned. user is nil when there is no such account.
+func (application *application) loginFailed(w http.ResponseWriter, r *http.Request, email, ip string, user *data.User) {
+	cfg := application.config.login
+
+	if user != nil {
+		application.auditUser(r, data.AuditLoginFailed, user.ID, map[string]string{"email": email, "reason": "invalid_password"})
+	} else {
+		application.audit(r, &data.AuditEvent{
+			Action:  data.AuditLoginFailed,
+			Details: map[string]string{"email": email, "reason": "unknown_email"},
+		})
+	}
+
+	err := application.models.LoginAttempts.InsertFailure(email, ip)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	since := time.Now().Add(-cfg.window)
+
+	emailFailures, err := application.models.LoginAttempts.FailuresForEmail(email, since)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	ipFailures, err := application.models.LoginAttempts.FailuresForIP(ip, since)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// Locked out attempts are refused before they get here, so any failure at or over
+	// a threshold is the one that starts a new lockout.
+	lockedUntil := time.Now().Add(cfg.lockoutDuration).UTC()
+
+	if ipFailures.Count >= cfg.ipLockoutThreshold {
+		application.log.PrintInfo("sign in locked out", map[string]string{
+			"reason":       "ip",
+			"ip":           ip,
+			"failures":     strconv.Itoa(ipFailures.Count),
+			"locked_until": lockedUntil.Format(time.RFC3339),
+		})
+	}
+
+	if emailFailures.Count >= cfg.lockoutThreshold {
+		application.log.PrintInfo("sign in locked out", map[string]string{
+			"reason":       "email",
+			"email":        email,
+			"ip":           ip,
+			"failures":     strconv.Itoa(emailFailures.Count),
+			"locked_until": lockedUntil.Format(time.RFC3339),
+		})
+
+		if user != nil {
+			application.background(func() {
+				suspiciousSignInInfo := map[string]any{
+					"failedAttempts": emailFailures.Count,
+					"ip":             ip,
+					"lockedUntil":    lockedUntil.Format(time.RFC1123),
+				}
+
+				err := application.mailer.Send(user.Email, "suspicious_sign_in.tmpl", suspiciousSignInInfo)
+				if err != nil {
+					application.log.PrintError(err, nil)
+				}
+			})
+		}
+	}
+
+	application.invalidCredentialsResponse(w, r)
+}
+
+func (application *application) purgeLoginAttempts() error {
+	cfg := application.config.login
+
+	deleted, err := application.models.LoginAttempts.DeleteBefore(time.Now().Add(-cfg.window - cfg.lockoutDuration))
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		application.log.PrintInfo("purged old login attempts", map[string]string{
+			"count": fmt.Sprint(deleted),
+		})
+	}
+
+	return nil
+}
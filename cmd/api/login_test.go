@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useLoginSettings changes the sign in throttling settings for the length of a test.
+func useLoginSettings(t *testing.T, application *application, settings loginSettings) {
+	t.Helper()
+
+	previous := application.config.login
+	application.config.login = settings
+	t.Cleanup(func() { application.config.login = previous })
+}
+
+// fromIP returns a prepare function for send that makes the request come from ip. Any
+// headers are added as well.
+func fromIP(ip string, headers map[string]string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.RemoteAddr = ip + ":1234"
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+	}
+}
+
+func TestLoginThrottleDelays(t *testing.T) {
+	application, _ := newTestApplication(t)
+
+	useLoginSettings(t, application, loginSettings{
+		window:             15 * time.Minute,
+		delayAfter:         2,
+		maxDelay:           1500 * time.Millisecond,
+		lockoutThreshold:   100,
+		ipLockoutThreshold: 100,
+		lockoutDuration:    15 * time.Minute,
+	})
+
+	const email = "throttled@example.com"
+
+	// Each case adds one failure to the ones before it.
+	tests := []time.Duration{
+		0,
+		0,
+		baseLoginDelay,
+		2 * baseLoginDelay,
+		1500 * time.Millisecond,
+		1500 * time.Millisecond,
+	}
+
+	for i, want := range tests {
+		t.Run(fmt.Sprintf("%d failures", i), func(t *testing.T) {
+			throttle, err := application.loginThrottle(email, "198.51.100.10")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if throttle.delay != want {
+				t.Errorf("got a delay of %s; want %s", throttle.delay, want)
+			}
+
+			if !throttle.lockedUntil.IsZero() {
+				t.Errorf("locked out until %s", throttle.lockedUntil)
+			}
+
+			err = application.models.LoginAttempts.InsertFailure(email, "198.51.100.10")
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	useLoginSettings(t, application, loginSettings{
+		window:             15 * time.Minute,
+		delayAfter:         100,
+		lockoutThreshold:   3,
+		ipLockoutThreshold: 100,
+		lockoutDuration:    15 * time.Minute,
+	})
+
+	user := insertTestUser(t, application, "locked-out@example.com", true)
+
+	attempt := func(password string) *testResponse {
+		return send(t, routes, http.MethodPost, "/tokens/authentication", map[string]any{"email": user.Email, "password": password}, fromIP("198.51.100.20", nil))
+	}
+
+	// The cases run in order. The third failure starts the lockout and warns the owner.
+	tests := []struct {
+		name       string
+		password   string
+		wantStatus int
+		wantEmails int
+	}{
+		{name: "first failure", password: "wr0ngpa55word", wantStatus: http.StatusUnauthorized, wantEmails: 0},
+		{name: "second failure", password: "wr0ngpa55word", wantStatus: http.StatusUnauthorized, wantEmails: 0},
+		{name: "third failure", password: "wr0ngpa55word", wantStatus: http.StatusUnauthorized, wantEmails: 1},
+		{name: "right password while locked out", password: testPassword, wantStatus: http.StatusTooManyRequests, wantEmails: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := attempt(tt.password)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			if res.StatusCode == http.StatusTooManyRequests && res.Header.Get("Retry-After") == "" {
+				t.Error("missing Retry-After header")
+			}
+
+			application.wg.Wait()
+
+			sent := testMailer.sentTo(user.Email, "suspicious_sign_in.tmpl")
+			if len(sent) != tt.wantEmails {
+				t.Fatalf("got %d lockout emails; want %d", len(sent), tt.wantEmails)
+			}
+		})
+	}
+
+	sent := testMailer.sentTo(user.Email, "suspicious_sign_in.tmpl")[0]
+	info, _ := sent.data.(map[string]any)
+	if info["ip"] != "198.51.100.20" || info["failedAttempts"] != 3 {
+		t.Errorf("got lockout email %v; want the IP address and the number of failures", info)
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	useLoginSettings(t, application, loginSettings{
+		window:             15 * time.Minute,
+		delayAfter:         100,
+		lockoutThreshold:   100,
+		ipLockoutThreshold: 2,
+		lockoutDuration:    15 * time.Minute,
+	})
+
+	user := insertTestUser(t, application, "ip-locked-out@example.com", true)
+
+	// Without a trusted proxy the client can't escape the lockout by claiming to be
+	// someone else.
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		forwarded  string
+		wantStatus int
+	}{
+		{name: "first failure", email: "nobody-1@example.com", password: "wr0ngpa55word", forwarded: "192.0.2.101", wantStatus: http.StatusUnauthorized},
+		{name: "second failure", email: "nobody-2@example.com", password: "wr0ngpa55word", forwarded: "192.0.2.102", wantStatus: http.StatusUnauthorized},
+		{name: "another account", email: user.Email, password: testPassword, forwarded: "192.0.2.103", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prepare := fromIP("198.51.100.30", map[string]string{"X-Forwarded-For": tt.forwarded, "X-Real-IP": tt.forwarded})
+
+			res := send(t, routes, http.MethodPost, "/tokens/authentication", map[string]any{"email": tt.email, "password": tt.password}, prepare)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+		})
+	}
+
+	// Another address still gets through.
+	res := send(t, routes, http.MethodPost, "/tokens/authentication", map[string]any{"email": user.Email, "password": testPassword}, fromIP("198.51.100.31", nil))
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("got status %d from another address; want %d (%v)", res.StatusCode, http.StatusCreated, res.body)
+	}
+}
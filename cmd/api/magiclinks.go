@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+	"time"
+)
+
+// createMagicLinkTokenHandler emails a single-use sign in token. The response carries a
+// nonce that has to be sent back along with the token, which ties the token to the
+// client that asked for it: someone who gets hold of the email alone can't use it.
+func (application *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !application.magicLinkEmails.Allow(input.Email) {
+		application.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nonce := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	// As with password resets, the lookup happens in the background so the response is
+	// the same whether or not an account exists for the email address.
+	application.background(func() {
+		user, err := application.models.Users.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				application.log.PrintError(err, nil)
+			}
+			return
+		}
+
+		var token *data.Token
+
+		err = application.models.Transaction(func(tx data.Models) error {
+			err := tx.Token.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+			if err != nil {
+				return err
+			}
+
+			token, err = tx.Token.NewWithPayload(user.ID, 15*time.Minute, data.ScopeMagicLink, hashMagicLinkNonce(nonce))
+			return err
+		})
+		if err != nil {
+			application.log.PrintError(err, nil)
+			return
+		}
+
+		application.auditUser(r, data.AuditTokenCreated, user.ID, map[string]string{"reason": "magic_link"})
+
+		magicLinkInfo := map[string]any{
+			"magicLinkToken": token.Plaintext,
+		}
+
+		err = application.mailer.Send(user.Email, "token_magic_link.tmpl", magicLinkInfo)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{
+		"message": "if an account exists for this email address you will receive a sign in link",
+		"nonce":   nonce,
+	}
+
+	err = application.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMagicLinkAuthenticationTokenHandler exchanges a magic link token and its nonce
+// for authentication tokens. Following the link proves the user owns the email address,
+// so an account that has never been activated is activated. Disabled accounts are
+// refused before the token is used up.
+func (application *application) createMagicLinkAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token  string `json:"token"`
+		Nonce  string `json:"nonce"`
+		Cookie bool   `json:"cookie"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.Token)
+	v.Check(input.Nonce != "", "nonce", "must be provided")
+	application.validateCookieRequest(v, input.Cookie)
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := application.models.Token.Get(data.ScopeMagicLink, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashMagicLinkNonce(input.Nonce)), []byte(token.Payload)) != 1 {
+		application.auditUser(r, data.AuditLoginFailed, token.UserID, map[string]string{"reason": "invalid_magic_link_nonce"})
+		application.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, err := application.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsDisabled() {
+		application.disabledSignIn(w, r, user)
+		return
+	}
+
+	// Disabling an account leaves Activated alone, so this only activates accounts that
+	// have never been activated.
+	activating := !user.Activated
+
+	// Deleting the token by ID fails if a concurrent request got there first, so the
+	// token can only be used once.
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Token.DeleteByID(token.UserID, token.ID)
+		if err != nil {
+			return err
+		}
+
+		if !activating {
+			return nil
+		}
+
+		user.Activated = true
+
+		err = tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if activating {
+		application.invalidateAuthCache(user.ID)
+		application.auditUser(r, data.AuditUserActivated, user.ID, map[string]string{"method": "magic_link"})
+	}
+
+	application.signInOrChallenge(w, r, user, input.Cookie)
+}
+
+// hashMagicLinkNonce returns the form of a nonce that is stored with its token.
+func hashMagicLinkNonce(nonce string) string {
+	hash := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMagicLinkAuthentication(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	const nonce = "client-nonce"
+
+	tests := []struct {
+		name  string
+		email string
+		// activated and disabled describe the account before the link is followed.
+		activated bool
+		disabled  bool
+		nonce     string
+		// reuse follows the link a second time with the right nonce.
+		reuse bool
+
+		wantStatus      int
+		wantReuseStatus int
+		wantActivated   bool
+	}{
+		{
+			name:          "right nonce",
+			email:         "magic-right@example.com",
+			activated:     true,
+			nonce:         nonce,
+			wantStatus:    http.StatusCreated,
+			wantActivated: true,
+		},
+		{
+			name:            "link can only be used once",
+			email:           "magic-reuse@example.com",
+			activated:       true,
+			nonce:           nonce,
+			reuse:           true,
+			wantStatus:      http.StatusCreated,
+			wantReuseStatus: http.StatusUnauthorized,
+			wantActivated:   true,
+		},
+		{
+			// A wrong nonce is refused without using the link up, so the client that
+			// asked for it can still sign in.
+			name:            "wrong nonce",
+			email:           "magic-wrong@example.com",
+			activated:       true,
+			nonce:           "stolen-link-only",
+			reuse:           true,
+			wantStatus:      http.StatusUnauthorized,
+			wantReuseStatus: http.StatusCreated,
+			wantActivated:   true,
+		},
+		{
+			name:          "activates a new account",
+			email:         "magic-new@example.com",
+			nonce:         nonce,
+			wantStatus:    http.StatusCreated,
+			wantActivated: true,
+		},
+		{
+			name:            "disabled account",
+			email:           "magic-disabled@example.com",
+			activated:       true,
+			disabled:        true,
+			nonce:           nonce,
+			reuse:           true,
+			wantStatus:      http.StatusForbidden,
+			wantReuseStatus: http.StatusForbidden,
+			wantActivated:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := insertTestUser(t, application, tt.email, tt.activated)
+
+			if tt.disabled {
+				now := time.Now()
+				user.DisabledAt = &now
+
+				err := application.models.Users.Update(user)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// This is the token createMagicLinkTokenHandler emails.
+			token, err := application.models.Token.NewWithPayload(user.ID, 15*time.Minute, data.ScopeMagicLink, hashMagicLinkNonce(nonce))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			follow := func(nonce string) *testResponse {
+				return send(t, routes, http.MethodPost, "/tokens/authentication/magic-link", map[string]any{
+					"token": token.Plaintext,
+					"nonce": nonce,
+				}, nil)
+			}
+
+			res := follow(tt.nonce)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			if tt.wantStatus == http.StatusCreated && res.string("authentication_token", "token") == "" {
+				t.Errorf("no access token in %v", res.body)
+			}
+
+			if tt.reuse {
+				res := follow(nonce)
+				if res.StatusCode != tt.wantReuseStatus {
+					t.Errorf("following the link again: got status %d; want %d (%v)", res.StatusCode, tt.wantReuseStatus, res.body)
+				}
+			}
+
+			user, err = application.models.Users.Get(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if user.Activated != tt.wantActivated {
+				t.Errorf("activated = %t; want %t", user.Activated, tt.wantActivated)
+			}
+		})
+	}
+}
@@ -2,16 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"golang.org/x/time/rate"
 	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/encryption"
 	"greenlight.badrchoubai.dev/internal/jsonlog"
 	"greenlight.badrchoubai.dev/internal/mailer"
+	"greenlight.badrchoubai.dev/internal/signedtoken"
 	"greenlight.badrchoubai.dev/internal/vcs"
+	"net"
+	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +58,53 @@ type (
 		sender   string
 	}
 
+	authSettings struct {
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+		// tokenMode is either "opaque", where access tokens are looked up in the
+		// database, or "signed", where they carry their own claims.
+		tokenMode   string
+		signingKeys []*signedtoken.Key
+		signingKey  string
+		// cacheTTL and cacheSize bound the cache of token and permission lookups. A
+		// zero TTL disables it.
+		cacheTTL  time.Duration
+		cacheSize int
+		// cookieSessions lets clients ask for their tokens to be set as cookies
+		// rather than returned in the response body.
+		cookieSessions bool
+		cookieSameSite http.SameSite
+		// sweepInterval is how often expired tokens are deleted, sweepBatchSize at a
+		// time. A zero interval disables the sweeper.
+		sweepInterval  time.Duration
+		sweepBatchSize int
+	}
+
+	loginSettings struct {
+		window             time.Duration
+		delayAfter         int
+		maxDelay           time.Duration
+		lockoutThreshold   int
+		ipLockoutThreshold int
+		lockoutDuration    time.Duration
+	}
+
+	mfaSettings struct {
+		encryptionKey string
+		issuer        string
+	}
+
+	accountSettings struct {
+		deletionGracePeriod time.Duration
+		// defaultRole is assigned to every new user. Empty means new users start
+		// without any permissions.
+		defaultRole string
+		// registrationMode is "open", where anyone can register, "invite", where
+		// registering takes an invitation, or "closed".
+		registrationMode string
+		invitationTTL    time.Duration
+	}
+
 	config struct {
 		cors
 		port    int
@@ -56,14 +112,48 @@ type (
 		db      connectionPoolSettings
 		limiter rateLimiterSettings
 		smtp    smtpOptions
+		auth    authSettings
+		account accountSettings
+		mfa     mfaSettings
+		login   loginSettings
+		// passwords are the settings for new password hashes, and maxConcurrentHashes
+		// bounds how many hashes sign ins and registrations run at once.
+		passwords           data.PasswordParams
+		maxConcurrentHashes int
+		// trustedProxies are the reverse proxies whose X-Forwarded-For and X-Real-IP
+		// headers are believed.
+		trustedProxies []*net.IPNet
 	}
 
 	application struct {
 		config config
 		log    *jsonlog.Logger
 		models data.Models
-		mailer mailer.Mailer
+		mailer mailer.IMailer
 		wg     sync.WaitGroup
+
+		// shutdown is closed when the server starts shutting down, which stops the
+		// periodic jobs.
+		shutdown chan struct{}
+
+		activationEmails *keyedLimiter
+		magicLinkEmails  *keyedLimiter
+
+		// signer and revocations are only set when access tokens are signed.
+		signer      *signedtoken.Signer
+		revocations *revocationList
+
+		mfaCipher   *encryption.Cipher
+		mfaAttempts *keyedLimiter
+
+		passwordHashes hashSlots
+
+		// authCache is nil when caching is disabled.
+		authCache *authCache
+
+		tokenSweeps *expvar.Map
+		// auditFailures counts the audit events that couldn't be written.
+		auditFailures *expvar.Int
 	}
 )
 
@@ -75,7 +165,7 @@ func main() {
 	flag.StringVar(&config.env, "env", "development", "Environment (development|staging|production)")
 
 	// Setup DB Connection Pool
-	flag.StringVar(&config.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	flag.StringVar(&config.db.dsn, "db-dsn", "", "PostgreSQL DSN (or memory:// for an in-memory store)")
 	flag.IntVar(&config.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&config.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&config.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
@@ -92,11 +182,93 @@ func main() {
 	flag.StringVar(&config.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&config.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.badrchoubai.dev>", "SMTP sender")
 
+	// Setup authentication token lifetimes
+	flag.DurationVar(&config.auth.accessTokenTTL, "auth-access-token-ttl", 15*time.Minute, "Auth: lifetime of access tokens")
+	flag.DurationVar(&config.auth.refreshTokenTTL, "auth-refresh-token-ttl", 30*24*time.Hour, "Auth: lifetime of refresh tokens")
+
+	flag.StringVar(&config.auth.tokenMode, "auth-token-mode", "opaque", "Auth: access token format (opaque|signed)")
+	flag.Func("auth-signing-keys", "Auth: signing keys as kid:hs256|ed25519:base64 (space-separated)", func(specs string) error {
+		for _, spec := range strings.Fields(specs) {
+			key, err := signedtoken.ParseKey(spec)
+			if err != nil {
+				return err
+			}
+			config.auth.signingKeys = append(config.auth.signingKeys, key)
+		}
+		return nil
+	})
+	flag.StringVar(&config.auth.signingKey, "auth-signing-key-id", "", "Auth: id of the key new tokens are signed with (defaults to the first key)")
+	flag.DurationVar(&config.auth.cacheTTL, "auth-cache-ttl", 30*time.Second, "Auth: how long token and permission lookups are cached (0 disables the cache)")
+	flag.IntVar(&config.auth.cacheSize, "auth-cache-size", 10000, "Auth: maximum number of cached token and permission lookups of each kind")
+	flag.DurationVar(&config.auth.sweepInterval, "auth-token-sweep-interval", 10*time.Minute, "Auth: how often expired tokens are deleted (0 disables the sweeper)")
+	flag.IntVar(&config.auth.sweepBatchSize, "auth-token-sweep-batch-size", 1000, "Auth: maximum number of expired tokens deleted per statement")
+	flag.BoolVar(&config.auth.cookieSessions, "auth-cookie-sessions", false, "Auth: allow browser clients to keep their session in cookies")
+	config.auth.cookieSameSite = http.SameSiteLaxMode
+	flag.Func("auth-cookie-same-site", "Auth: SameSite attribute of session cookies (lax|strict|none, default lax)", func(s string) error {
+		switch s {
+		case "lax":
+			config.auth.cookieSameSite = http.SameSiteLaxMode
+		case "strict":
+			config.auth.cookieSameSite = http.SameSiteStrictMode
+		case "none":
+			config.auth.cookieSameSite = http.SameSiteNoneMode
+		default:
+			return errors.New("must be lax, strict or none")
+		}
+		return nil
+	})
+
+	// Setup password hashing
+	config.passwords = data.DefaultPasswordParams
+	flag.StringVar(&config.passwords.Algorithm, "password-algorithm", config.passwords.Algorithm, "Passwords: algorithm for new hashes (argon2id|bcrypt)")
+	flag.IntVar(&config.passwords.BcryptCost, "password-bcrypt-cost", config.passwords.BcryptCost, "Passwords: bcrypt cost")
+	flag.Func("password-argon2-memory", "Passwords: argon2id memory in KiB (default 65536)", func(s string) error {
+		n, err := strconv.ParseUint(s, 10, 32)
+		config.passwords.Argon2Memory = uint32(n)
+		return err
+	})
+	flag.Func("password-argon2-iterations", "Passwords: argon2id iterations (default 3)", func(s string) error {
+		n, err := strconv.ParseUint(s, 10, 32)
+		config.passwords.Argon2Iterations = uint32(n)
+		return err
+	})
+	flag.Func("password-argon2-parallelism", "Passwords: argon2id threads (default 2)", func(s string) error {
+		n, err := strconv.ParseUint(s, 10, 8)
+		config.passwords.Argon2Parallelism = uint8(n)
+		return err
+	})
+
+	flag.IntVar(&config.maxConcurrentHashes, "password-max-concurrent-hashes", runtime.NumCPU(), "Passwords: most hashes sign ins and registrations run at once (0 for no limit)")
+
+	// Setup sign in brute-force protection
+	flag.DurationVar(&config.login.window, "login-window", 15*time.Minute, "Login: period over which failed sign in attempts are counted")
+	flag.IntVar(&config.login.delayAfter, "login-delay-after", 3, "Login: failures per email before responses are delayed")
+	flag.DurationVar(&config.login.maxDelay, "login-max-delay", 5*time.Second, "Login: longest delay added to a sign in attempt")
+	flag.IntVar(&config.login.lockoutThreshold, "login-lockout-threshold", 10, "Login: failures per email before it is locked out")
+	flag.IntVar(&config.login.ipLockoutThreshold, "login-ip-lockout-threshold", 50, "Login: failures per IP address before it is locked out")
+	flag.DurationVar(&config.login.lockoutDuration, "login-lockout-duration", 15*time.Minute, "Login: how long a lockout lasts after the last failure")
+
+	// Setup two-factor authentication
+	flag.StringVar(&config.mfa.encryptionKey, "mfa-encryption-key", "", "MFA: base64 encoded 32 byte key that encrypts TOTP secrets (required outside development)")
+	flag.StringVar(&config.mfa.issuer, "mfa-issuer", "Greenlight", "MFA: issuer name shown in authenticator apps")
+
+	// Setup account lifecycle settings
+	flag.DurationVar(&config.account.deletionGracePeriod, "account-deletion-grace-period", 30*24*time.Hour, "Accounts: time between a deletion request and the account being purged")
+	flag.StringVar(&config.account.defaultRole, "account-default-role", "viewer", "Accounts: role assigned to new users (empty for none)")
+	flag.StringVar(&config.account.registrationMode, "account-registration-mode", "", "Accounts: who can register (open|invite|closed; defaults to invite in staging and open otherwise)")
+	flag.DurationVar(&config.account.invitationTTL, "account-invitation-ttl", 7*24*time.Hour, "Accounts: how long invitations stay valid")
+
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space-separated)", func(origins string) error {
 		config.cors.trustedOrigins = strings.Fields(origins)
 		return nil
 	})
 
+	flag.Func("trusted-proxies", "Reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted (space-separated IPs or CIDR ranges)", func(specs string) error {
+		proxies, err := parseTrustedProxies(specs)
+		config.trustedProxies = proxies
+		return err
+	})
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -108,30 +280,95 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
-	db, err := openDB(config)
+	err := data.SetPasswordParams(config.passwords)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 
-	defer db.Close()
+	var models data.Models
+
+	if config.db.dsn == "memory://" {
+		models = data.NewMemoryModels()
+
+		logger.PrintInfo("database: using in-memory store", nil)
+	} else {
+		db, err := openDB(config)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 
-	logger.PrintInfo("database: connection pool established", nil)
+		defer db.Close()
+
+		logger.PrintInfo("database: connection pool established", nil)
+
+		expvar.Publish("database", expvar.Func(func() any {
+			return db.Stats()
+		}))
+
+		models = data.NewModels(db)
+	}
+
+	switch config.account.registrationMode {
+	case "":
+		config.account.registrationMode = "open"
+		if config.env == "staging" {
+			config.account.registrationMode = "invite"
+		}
+	case "open", "invite", "closed":
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid -account-registration-mode %q", config.account.registrationMode), nil)
+	}
+
+	if config.account.defaultRole != "" {
+		_, err := models.Roles.Get(config.account.defaultRole)
+		if err != nil {
+			logger.PrintFatal(fmt.Errorf("default role %q: %w", config.account.defaultRole, err), nil)
+		}
+	}
 
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() any {
 		return runtime.NumGoroutine()
 	}))
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
-	}))
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
 	}))
 
+	var signer *signedtoken.Signer
+
+	switch config.auth.tokenMode {
+	case "opaque":
+	case "signed":
+		if len(config.auth.signingKeys) == 0 {
+			logger.PrintFatal(errors.New("-auth-signing-keys is required when -auth-token-mode=signed"), nil)
+		}
+
+		if config.auth.signingKey == "" {
+			config.auth.signingKey = config.auth.signingKeys[0].ID
+		}
+
+		var err error
+		signer, err = signedtoken.NewSigner(config.auth.signingKeys, config.auth.signingKey)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid -auth-token-mode %q", config.auth.tokenMode), nil)
+	}
+
+	if config.auth.sweepBatchSize < 1 {
+		logger.PrintFatal(errors.New("-auth-token-sweep-batch-size must be at least 1"), nil)
+	}
+
+	mfaCipher, err := newMFACipher(config, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	application := &application{
 		config: config,
 		log:    logger,
-		models: data.NewModels(db),
+		models: models,
 		mailer: mailer.New(
 			config.smtp.host,
 			config.smtp.port,
@@ -139,6 +376,39 @@ func main() {
 			config.smtp.password,
 			config.smtp.sender,
 		),
+		shutdown: make(chan struct{}),
+		// At most three activation or magic link emails per address, then one every ten
+		// minutes.
+		activationEmails: newKeyedLimiter(rate.Every(10*time.Minute), 3),
+		magicLinkEmails:  newKeyedLimiter(rate.Every(10*time.Minute), 3),
+		signer:           signer,
+		revocations:      newRevocationList(),
+		mfaCipher:        mfaCipher,
+		// Five two-factor codes per user, then one a minute.
+		mfaAttempts:    newKeyedLimiter(rate.Every(time.Minute), 5),
+		passwordHashes: newHashSlots(config.maxConcurrentHashes),
+		tokenSweeps:    expvar.NewMap("token_sweeps"),
+		auditFailures:  expvar.NewInt("audit_failures"),
+	}
+
+	if signer != nil {
+		err := application.refreshRevocations()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	if config.auth.cacheTTL > 0 {
+		application.authCache = newAuthCache(config.auth.cacheTTL, config.auth.cacheSize)
+
+		// Instances sharing a database tell each other about invalidations. The
+		// in-memory store only ever has one.
+		if config.db.dsn != "memory://" {
+			err := application.listenForAuthCacheInvalidations()
+			if err != nil {
+				logger.PrintFatal(err, nil)
+			}
+		}
 	}
 
 	// Start the HTTP server.
@@ -148,6 +418,34 @@ func main() {
 	}
 }
 
+// newMFACipher returns the cipher for TOTP secrets. In development a missing key is
+// replaced by a random one, which is fine for a throwaway database but means secrets
+// stored with it can't be read after a restart, so every other environment needs a key.
+func newMFACipher(cfg config, logger *jsonlog.Logger) (*encryption.Cipher, error) {
+	if cfg.mfa.encryptionKey == "" {
+		if cfg.env != "development" {
+			return nil, fmt.Errorf("-mfa-encryption-key is required in %s", cfg.env)
+		}
+
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.PrintInfo("mfa: no encryption key set, using a temporary one", nil)
+
+		return encryption.New(key)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.mfa.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("-mfa-encryption-key: %w", err)
+	}
+
+	return encryption.New(key)
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
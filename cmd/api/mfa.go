@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/totp"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errTooManyMFAAttempts = errors.New("too many two-factor authentication attempts")
+
+func (application *application) showMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	status := struct {
+		Enabled                bool       `json:"enabled"`
+		ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+		RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	}{}
+
+	mfa, err := application.models.MFA.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	if mfa != nil && mfa.Enabled() {
+		status.Enabled = true
+		status.ConfirmedAt = mfa.ConfirmedAt
+
+		status.RecoveryCodesRemaining, err = application.models.MFA.CountRecoveryCodes(user.ID)
+		if err != nil {
+			application.dataErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"mfa": status}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// enrollMFAHandler starts enrolment by generating a new TOTP secret. Two-factor
+// authentication isn't enforced until the user proves their app has the secret through
+// confirmMFAHandler.
+func (application *application) enrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	encrypted, err := application.mfaCipher.Encrypt(secret, mfaAdditionalData(user.ID))
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.models.MFA.Insert(&data.MFA{UserID: user.ID, Secret: encrypted})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("mfa", "two-factor authentication is already enabled")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.auditUser(r, data.AuditMFAEnrolled, user.ID, nil)
+
+	env := envelope{
+		"mfa": envelope{
+			"secret":      totp.EncodeSecret(secret),
+			"otpauth_uri": totp.URI(application.config.mfa.issuer, user.Email, secret),
+		},
+	}
+
+	err = application.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mfa, err := application.models.MFA.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa", "enrolment has not been started")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled() {
+		v.AddError("mfa", "two-factor authentication is already enabled")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := application.mfaCipher.Decrypt(mfa.Secret, mfaAdditionalData(user.ID))
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	counter, ok := totp.Validate(secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is invalid")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.MFA.Confirm(user.ID, counter)
+		if err != nil {
+			return err
+		}
+
+		return tx.MFA.ReplaceRecoveryCodes(user.ID, codes)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.auditUser(r, data.AuditMFAEnabled, user.ID, nil)
+
+	// The recovery codes are only ever shown here.
+	env := envelope{
+		"message":        "two-factor authentication is enabled, store these recovery codes somewhere safe",
+		"recovery_codes": codes,
+	}
+
+	err = application.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := application.reauthenticateWithMFA(w, r)
+	if !ok {
+		return
+	}
+
+	err := application.models.MFA.Delete(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditMFADisabled, user.ID, nil)
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication is disabled"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := application.reauthenticateWithMFA(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.models.MFA.ReplaceRecoveryCodes(user.ID, codes)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditRecoveryCodesRegenerated, user.ID, nil)
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// reauthenticateWithMFA reads a password and a second factor from the request body and
+// checks both, for changes that an attacker holding only a session shouldn't be able to
+// make. It writes the error response itself and reports whether the handler can go on.
+func (application *application) reauthenticateWithMFA(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		application.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	ok, err := application.verifySecondFactor(user, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, errTooManyMFAAttempts):
+			application.rateLimitExceededResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !ok {
+		v.AddError("code", "is invalid")
+		application.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (application *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+		Cookie   bool   `json:"cookie"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "", "code", "must be provided")
+	application.validateCookieRequest(v, input.Cookie)
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := application.models.Token.Get(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := application.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := application.verifySecondFactor(user, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, errTooManyMFAAttempts):
+			application.rateLimitExceededResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !ok {
+		application.auditUser(r, data.AuditLoginFailed, user.ID, map[string]string{"reason": "invalid_mfa_code"})
+		application.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// Deleting the token by ID fails if a concurrent request got there first, so one
+	// challenge can't be answered twice, say with two different recovery codes.
+	err = application.models.Token.DeleteByID(token.UserID, token.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.signIn(w, r, user, input.Cookie)
+}
+
+// verifySecondFactor checks a code from the user's authenticator app or one of their
+// recovery codes, using it up either way. Attempts are rate limited per user, since six
+// digit codes are easy to guess given enough tries.
+func (application *application) verifySecondFactor(user *data.User, code string) (bool, error) {
+	if !application.mfaAttempts.Allow(strconv.FormatInt(user.ID, 10)) {
+		return false, errTooManyMFAAttempts
+	}
+
+	mfa, err := application.models.MFA.Get(user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !mfa.Enabled() {
+		return false, nil
+	}
+
+	if len(code) != totp.Digits {
+		err := application.models.MFA.UseRecoveryCode(user.ID, code)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	secret, err := application.mfaCipher.Decrypt(mfa.Secret, mfaAdditionalData(user.ID))
+	if err != nil {
+		return false, err
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// A code that has been used once, or is older than one that has, is refused.
+	err = application.models.MFA.UseCounter(user.ID, counter)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// mfaAdditionalData ties an encrypted TOTP secret to its user, so it can't be decrypted
+// if copied onto another account.
+func mfaAdditionalData(userID int64) []byte {
+	return []byte("user_mfa:" + strconv.FormatInt(userID, 10))
+}
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
-	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/signedtoken"
 	"greenlight.badrchoubai.dev/internal/validator"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// requestIDRX matches the request IDs accepted from clients and proxies. Anything else is
+// replaced, so IDs are safe to log and search for.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 // Global Rate Limiter
 //func (app *application) rateLimit(next http.Handler) http.Handler {
 //	limiter := rate.NewLimiter(2, 4)
@@ -57,7 +64,7 @@ func (application *application) rateLimiter(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if application.config.limiter.enabled {
-			ip := realip.FromRequest(r)
+			ip := application.clientIP(r)
 
 			mu.Lock()
 
@@ -95,6 +102,30 @@ func (application *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestID gives every request an ID, echoed in the X-Request-ID response header, that
+// ties together its log lines and audit events. An ID set by the client or a proxy is
+// kept when it is well formed.
+func (application *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validator.Matches(id, requestIDRX) {
+			randomBytes := make([]byte, 16)
+			_, err := rand.Read(randomBytes)
+			if err != nil {
+				application.serverErrorResponse(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(randomBytes)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, application.contextSetRequestID(r, id))
+	})
+}
+
 func (application *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -102,43 +133,180 @@ func (application *application) authenticate(next http.Handler) http.Handler {
 		authorizationHeader := r.Header.Get("Authorization")
 
 		if authorizationHeader == "" {
+			if cookie, err := r.Cookie(sessionCookieName); err == nil && application.config.auth.cookieSessions {
+				application.authenticateCookie(w, r, next, cookie.Value)
+				return
+			}
+
 			r = application.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
+
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			application.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			application.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		application.authenticateToken(w, r, next, headerParts[1], application.invalidAuthenticationTokenResponse)
+	})
+}
+
+// authenticateToken authenticates a request made with an access token, calling invalid
+// if the token isn't valid.
+func (application *application) authenticateToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string, invalid http.HandlerFunc) {
+	if application.signer != nil && signedtoken.LooksSigned(token) {
+		application.authenticateSigned(w, r, next, token, invalid)
+		return
+	}
 
-		v := validator.New()
+	v := validator.New()
 
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			application.invalidAuthenticationTokenResponse(w, r)
-			return
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		invalid(w, r)
+		return
+	}
+
+	user, err := application.authCache.userForToken(token, func() (*data.User, error) {
+		return application.models.Users.GetForToken(data.ScopeAuthentication, token)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			invalid(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
 		}
+		return
+	}
 
-		user, err := application.models.Users.GetForToken(data.ScopeAuthentication, token)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				application.invalidAuthenticationTokenResponse(w, r)
-			default:
-				application.serverErrorResponse(w, r, err)
+	if user.IsDisabled() {
+		invalid(w, r)
+		return
+	}
+
+	if application.authCache.touchDue(token) {
+		application.background(func() {
+			err := application.models.Token.Touch(data.ScopeAuthentication, token)
+			if err != nil {
+				application.log.PrintError(err, nil)
 			}
-			return
-		}
+		})
+	}
+
+	r = application.contextSetUser(r, user)
+	r = application.contextSetToken(r, token)
+
+	next.ServeHTTP(w, r)
+}
+
+// authenticateCookie authenticates a request made with a session cookie. Browsers send
+// cookies with every request, so requests that change anything must also pass the CSRF
+// check, and a stale cookie is dropped rather than failing the request: the client may
+// well be about to sign in again.
+func (application *application) authenticateCookie(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	w.Header().Add("Vary", "Cookie")
+
+	if !application.validCSRFToken(r) {
+		application.invalidCSRFTokenResponse(w, r)
+		return
+	}
 
-		r = application.contextSetUser(r, user)
+	application.authenticateToken(w, r, next, token, func(w http.ResponseWriter, r *http.Request) {
+		application.clearSessionCookies(w, r)
 
+		r = application.contextSetUser(r, data.AnonymousUser)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateSigned authenticates a request made with a signed access token. Everything
+// needed is in the token's claims, so the only lookup is in the in-process deny-list;
+// the user in the request context has just the ID and activation status filled in.
+func (application *application) authenticateSigned(w http.ResponseWriter, r *http.Request, next http.Handler, token string, invalid http.HandlerFunc) {
+	claims, err := application.signer.Verify(token, time.Now())
+	if err != nil {
+		invalid(w, r)
+		return
+	}
+
+	if application.revocations.Contains(claims) {
+		invalid(w, r)
+		return
+	}
+
+	user := &data.User{ID: claims.Subject, Activated: claims.Activated}
+
+	r = application.contextSetUser(r, user)
+	r = application.contextSetToken(r, token)
+	r = application.contextSetClaims(r, claims)
+
+	next.ServeHTTP(w, r)
+}
+
+// authenticateAPIKey authenticates a request made with an API key, sent as
+// "Authorization: ApiKey <key>". The request acts as the key's user, limited to the key's
+// permissions by requirePermission.
+func (application *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		application.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	key, err := application.models.APIKeys.Get(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAPIKeyResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !key.AllowsIP(application.clientIP(r)) {
+		application.apiKeyAddressNotAllowedResponse(w, r)
+		return
+	}
+
+	user, err := application.models.Users.Get(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAPIKeyResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsDisabled() {
+		application.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	application.background(func() {
+		err := application.models.APIKeys.Touch(key.ID)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	r = application.contextSetUser(r, user)
+	r = application.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 func (application *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := application.contextGetUser(r)
@@ -167,17 +335,79 @@ func (application *application) requireActivatedUser(next http.HandlerFunc) http
 	return application.requireAuthenticatedUser(fn)
 }
 
+// requireUserSession keeps API keys away from handlers that manage the account or its
+// credentials, which only the user themselves should reach.
+func (application *application) requireUserSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if application.contextGetAPIKey(r) != nil {
+			application.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return application.requireAuthenticatedUser(fn)
+}
+
+// requireCurrentUser is for handlers that need the whole user record rather than just
+// the ID. Requests made with a signed access token only carry the claims, so the record
+// is loaded for them here. API keys are refused.
+func (application *application) requireCurrentUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if application.contextGetAPIKey(r) != nil {
+			application.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		if application.contextGetClaims(r) != nil {
+			user, err := application.models.Users.Get(application.contextGetUser(r).ID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					application.invalidAuthenticationTokenResponse(w, r)
+				default:
+					application.dataErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if user.IsDisabled() {
+				application.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = application.contextSetUser(r, user)
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return application.requireActivatedUser(fn)
+}
+
 func (application *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := application.contextGetUser(r)
 
-		permissions, err := application.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			application.serverErrorResponse(w, r, err)
-			return
+		var permissions data.Permissions
+
+		if claims := application.contextGetClaims(r); claims != nil {
+			permissions = claims.Permissions
+		} else {
+			var err error
+			permissions, err = application.authCache.permissionsForUser(user.ID, func() (data.Permissions, error) {
+				return application.models.Permissions.GetAllForUser(user.ID)
+			})
+			if err != nil {
+				application.dataErrorResponse(w, r, err)
+				return
+			}
 		}
 
-		if !permissions.Include(code) {
+		r = application.contextSetPermissions(r, permissions)
+
+		if !application.hasPermission(r, code) {
 			application.notPermittedResponse(w, r)
 			return
 		}
@@ -188,6 +418,23 @@ func (application *application) requirePermission(code string, next http.Handler
 	return application.requireActivatedUser(fn)
 }
 
+// hasPermission reports whether the request holds code, using the permissions that
+// requirePermission stored in the context. An API key only grants what it was created
+// with, and only while its user still holds the permission.
+func (application *application) hasPermission(r *http.Request, code string) bool {
+	if !application.contextGetPermissions(r).Include(code) {
+		return false
+	}
+
+	if key := application.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+		return false
+	}
+
+	return true
+}
+
+// enableCORS lets trusted origins call the API. With cookie sessions on they may also
+// send credentials, which browsers only allow when the exact origin is echoed back.
 func (application *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -198,9 +445,13 @@ func (application *application) enableCORS(next http.Handler) http.Handler {
 				if origin == application.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
+					if application.config.auth.cookieSessions {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+csrfHeaderName)
 
 						w.WriteHeader(http.StatusOK)
 						return
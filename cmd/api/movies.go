@@ -36,7 +36,7 @@ func (application *application) listMoviesHandler(w http.ResponseWriter, r *http
 
 	movies, metadata, err := application.models.Movies.GetAll(qsValues.Title, qsValues.Genres, qsValues.FilterOptions)
 	if err != nil {
-		application.serverErrorResponse(w, r, err)
+		application.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -62,11 +62,15 @@ func (application *application) createMovieHandler(w http.ResponseWriter, r *htt
 
 	v := validator.New()
 
+	userID := application.contextGetUser(r).ID
+
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		CreatedBy: &userID,
+		UpdatedBy: &userID,
 	}
 
 	if data.ValidateMovie(v, movie); !v.Valid() {
@@ -76,7 +80,7 @@ func (application *application) createMovieHandler(w http.ResponseWriter, r *htt
 
 	err = application.models.Movies.Insert(movie)
 	if err != nil {
-		application.serverErrorResponse(w, r, err)
+		application.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -102,7 +106,7 @@ func (application *application) showMovieHandler(w http.ResponseWriter, r *http.
 		case errors.Is(err, data.ErrRecordNotFound):
 			application.notFoundResponse(w, r)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -126,11 +130,15 @@ func (application *application) updateMovieHandler(w http.ResponseWriter, r *htt
 		case errors.Is(err, data.ErrRecordNotFound):
 			application.notFoundResponse(w, r)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if !application.authorizeMovie(w, r, updateMoviePolicy, movie) {
+		return
+	}
+
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int32        `json:"year"`
@@ -167,13 +175,16 @@ func (application *application) updateMovieHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
+	userID := application.contextGetUser(r).ID
+	movie.UpdatedBy = &userID
+
 	err = application.models.Movies.Update(movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			application.editConflictResponse(w, r)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -191,13 +202,28 @@ func (application *application) deleteMovieHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	err = application.models.Movies.Delete(id)
+	movie, err := application.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.notFoundResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !application.authorizeMovie(w, r, deleteMoviePolicy, movie) {
+		return
+	}
+
+	err = application.models.Movies.Delete(movie.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			application.notFoundResponse(w, r)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+	"testing"
+)
+
+func TestMovieOwnership(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	// signInWith adds a user with the permissions and returns their ID and access token.
+	signInWith := func(email string, permissions ...string) (int64, func(r *http.Request)) {
+		user := insertTestUser(t, application, email, true)
+
+		err := application.models.Permissions.AddForUser(user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return user.ID, bearer(signIn(t, routes, email, false).string("authentication_token", "token"))
+	}
+
+	creatorID, creator := signInWith("movie-creator@example.com", "movies:read", "movies:write")
+	_, editor := signInWith("movie-editor@example.com", "movies:read", "movies:write")
+	_, admin := signInWith("movie-admin@example.com", "movies:read", "movies:write", "movies:admin")
+
+	// insertMovie adds a movie, created by createdBy unless it is nil.
+	insertMovie := func(createdBy *int64) *data.Movie {
+		movie := &data.Movie{Title: "Owned", Year: 2000, Runtime: 100, Genres: []string{"drama"}, CreatedBy: createdBy}
+
+		err := application.models.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return movie
+	}
+
+	tests := []struct {
+		name       string
+		createdBy  *int64
+		method     string
+		as         func(r *http.Request)
+		wantStatus int
+	}{
+		{name: "creator updates", createdBy: &creatorID, method: http.MethodPatch, as: creator, wantStatus: http.StatusOK},
+		{name: "another editor updates", createdBy: &creatorID, method: http.MethodPatch, as: editor, wantStatus: http.StatusForbidden},
+		{name: "movies:admin updates", createdBy: &creatorID, method: http.MethodPatch, as: admin, wantStatus: http.StatusOK},
+		{name: "creator deletes", createdBy: &creatorID, method: http.MethodDelete, as: creator, wantStatus: http.StatusOK},
+		{name: "another editor deletes", createdBy: &creatorID, method: http.MethodDelete, as: editor, wantStatus: http.StatusForbidden},
+		{name: "movies:admin deletes", createdBy: &creatorID, method: http.MethodDelete, as: admin, wantStatus: http.StatusOK},
+		{name: "editor updates a movie without a creator", method: http.MethodPatch, as: creator, wantStatus: http.StatusForbidden},
+		{name: "movies:admin updates a movie without a creator", method: http.MethodPatch, as: admin, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := insertMovie(tt.createdBy)
+
+			var body any
+			if tt.method == http.MethodPatch {
+				body = map[string]any{"title": "Changed"}
+			}
+
+			res := send(t, routes, tt.method, fmt.Sprintf("/api/v1/movies/%d", movie.ID), body, tt.as)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			_, err := application.models.Movies.Get(movie.ID)
+			if deleted := err != nil; deleted != (tt.method == http.MethodDelete && tt.wantStatus == http.StatusOK) {
+				t.Errorf("movie deleted: %t (%v)", deleted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+)
+
+// listPermissionsHandler returns the catalogue of concrete permissions, so admin tools
+// can discover what can be granted. Wildcards cover these codes rather than appear in
+// the catalogue themselves.
+func (application *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := application.models.Permissions.GetAll()
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"permissions": permissions,
+		"wildcard":    data.PermissionWildcard,
+	}
+
+	err = application.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
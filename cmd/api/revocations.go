@@ -0,0 +1,118 @@
+package main
+
+import (
+	"greenlight.badrchoubai.dev/internal/signedtoken"
+	"sync"
+	"time"
+)
+
+// revocationList is the in-process copy of the signed access token deny-list, so that
+// checking a token doesn't need a database round trip. Revocations made by this instance
+// are added straight away; those made by other instances arrive with the next refresh.
+type revocationList struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+	// users maps a user ID to the time before which every signed access token issued
+	// to the user is revoked.
+	users map[int64]time.Time
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		revoked: make(map[string]time.Time),
+		users:   make(map[int64]time.Time),
+	}
+}
+
+func (rl *revocationList) Add(id string, expiry time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.revoked[id] = expiry
+}
+
+func (rl *revocationList) AddUser(userID int64, issuedBefore time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if issuedBefore.After(rl.users[userID]) {
+		rl.users[userID] = issuedBefore
+	}
+}
+
+// Contains reports whether the token with the given claims has been revoked, either by
+// itself or along with every other token issued to its user up to then. Claims only
+// carry the issue time to the second, so a token issued in the same second as a
+// revocation of all of the user's tokens counts as revoked.
+func (rl *revocationList) Contains(claims *signedtoken.Claims) bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	if _, found := rl.revoked[claims.ID]; found {
+		return true
+	}
+
+	issuedBefore, found := rl.users[claims.Subject]
+	return found && claims.IssuedAt <= issuedBefore.Unix()
+}
+
+// refreshRevocations replaces the local deny-list with the unexpired entries stored in
+// the database, which also drops the entries whose tokens have expired by now.
+func (application *application) refreshRevocations() error {
+	tokens, err := application.models.RevokedTokens.GetAllUnexpired()
+	if err != nil {
+		return err
+	}
+
+	userTokens, err := application.models.RevokedTokens.GetAllUnexpiredForUsers()
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		revoked[token.ID] = token.Expiry
+	}
+
+	users := make(map[int64]time.Time, len(userTokens))
+	for _, tokens := range userTokens {
+		users[tokens.UserID] = tokens.IssuedBefore
+	}
+
+	application.revocations.mu.Lock()
+	application.revocations.revoked = revoked
+	application.revocations.users = users
+	application.revocations.mu.Unlock()
+
+	return nil
+}
+
+// revokeSignedToken adds a signed access token to the deny-list until it expires.
+func (application *application) revokeSignedToken(id string, expiry time.Time) error {
+	err := application.models.RevokedTokens.Insert(id, expiry)
+	if err != nil {
+		return err
+	}
+
+	application.revocations.Add(id, expiry)
+	return nil
+}
+
+// revokeUserSignedTokens adds every signed access token issued to the user so far to the
+// deny-list, until the last of them has expired. Stored tokens are deleted separately.
+func (application *application) revokeUserSignedTokens(userID int64) error {
+	if application.signer == nil {
+		return nil
+	}
+
+	issuedBefore := time.Now().Truncate(time.Second)
+	expiry := issuedBefore.Add(application.config.auth.accessTokenTTL + time.Second)
+
+	err := application.models.RevokedTokens.InsertForUser(userID, issuedBefore, expiry)
+	if err != nil {
+		return err
+	}
+
+	application.revocations.AddUser(userID, issuedBefore)
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/signedtoken"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useSignedTokens switches the test application to signed access tokens for the length
+// of a test.
+func useSignedTokens(t *testing.T, application *application) {
+	t.Helper()
+
+	key, err := signedtoken.ParseKey("test:hs256:" + base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := signedtoken.NewSigner([]*signedtoken.Key{key}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	application.signer = signer
+	t.Cleanup(func() { application.signer = nil })
+}
+
+func TestSignedTokenRevocation(t *testing.T) {
+	application, routes := newTestApplication(t)
+	useSignedTokens(t, application)
+
+	admin := insertTestAdmin(t, application, "revocation-admin@example.com")
+
+	tests := []struct {
+		name string
+		// revoke is done by, or to, the user who holds both sessions. It returns the
+		// access token that must no longer work.
+		revoke func(t *testing.T, user *data.User, current, other *testResponse) string
+	}{
+		{
+			name: "sign out of every session",
+			revoke: func(t *testing.T, user *data.User, current, other *testResponse) string {
+				send(t, routes, http.MethodDelete, "/tokens/authentication/all", nil, bearer(current.string("authentication_token", "token")))
+				return other.string("authentication_token", "token")
+			},
+		},
+		{
+			name: "password reset",
+			revoke: func(t *testing.T, user *data.User, current, other *testResponse) string {
+				token, err := application.models.Token.New(user.ID, time.Hour, data.ScopePasswordReset)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				send(t, routes, http.MethodPut, "/users/password", map[string]any{"password": "n3wpa55word", "token": token.Plaintext}, nil)
+				return other.string("authentication_token", "token")
+			},
+		},
+		{
+			name: "session revoked",
+			revoke: func(t *testing.T, user *data.User, current, other *testResponse) string {
+				token := current.string("authentication_token", "token")
+
+				sessions := send(t, routes, http.MethodGet, "/users/me/sessions", nil, bearer(token))
+				list, _ := sessions.body["sessions"].([]any)
+				for _, s := range list {
+					session, _ := s.(map[string]any)
+					if session["current"] == false {
+						send(t, routes, http.MethodDelete, fmt.Sprintf("/users/me/sessions/%s", session["id"]), nil, bearer(token))
+					}
+				}
+
+				return other.string("authentication_token", "token")
+			},
+		},
+		{
+			name: "refresh token reused",
+			revoke: func(t *testing.T, user *data.User, current, other *testResponse) string {
+				refresh := map[string]any{"refresh_token": other.string("refresh_token", "token")}
+
+				rotated := send(t, routes, http.MethodPost, "/tokens/refresh", refresh, nil)
+				send(t, routes, http.MethodPost, "/tokens/refresh", refresh, nil)
+
+				return rotated.string("authentication_token", "token")
+			},
+		},
+		{
+			name: "permission revoked",
+			revoke: func(t *testing.T, user *data.User, current, other *testResponse) string {
+				err := application.models.Permissions.AddForUser(user.ID, "movies:write")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				adminSession := signIn(t, routes, admin.Email, false)
+				send(t, routes, http.MethodDelete, fmt.Sprintf("/api/v1/admin/users/%d/permissions", user.ID),
+					map[string]any{"permissions": []string{"movies:write"}}, bearer(adminSession.string("authentication_token", "token")))
+
+				return other.string("authentication_token", "token")
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := insertTestUser(t, application, fmt.Sprintf("revocation-%d@example.com", i), true)
+
+			current := signIn(t, routes, user.Email, false)
+			other := signIn(t, routes, user.Email, false)
+
+			if !signedtoken.LooksSigned(other.string("authentication_token", "token")) {
+				t.Fatalf("expected a signed access token, got %v", other.body)
+			}
+
+			token := tt.revoke(t, user, current, other)
+			if token == "" {
+				t.Fatal("the revoking request didn't go through")
+			}
+
+			res := send(t, routes, http.MethodGet, "/users/me", nil, bearer(token))
+			if res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("got status %d for a revoked token; want %d", res.StatusCode, http.StatusUnauthorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// errAdminLockout is returned when an admin's change would take users:admin away from
+// themselves, leaving nobody able to undo it from the API.
+var errAdminLockout = errors.New("admin would lose users:admin")
+
+func (application *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := application.models.Roles.GetAll()
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string           `json:"name"`
+		Permissions data.Permissions `json:"permissions"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := application.models.Permissions.GetAll()
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if data.ValidateRole(v, role, known); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		return tx.Roles.Insert(role)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.audit(r, &data.AuditEvent{
+		Action:  data.AuditRoleCreated,
+		Details: map[string]string{"role": role.Name, "permissions": strings.Join(role.Permissions, ",")},
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/admin/roles/%s", role.Name))
+
+	err = application.writeJSON(w, http.StatusCreated, envelope{"role": role}, headers)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) showRoleHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	role, err := application.models.Roles.Get(params.ByName("name"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.notFoundResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) addUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	application.changeUserRoles(w, r, data.AuditRolesAssigned, func(tx data.Models, userID int64, names []string) error {
+		return tx.Roles.AddForUser(userID, names...)
+	})
+}
+
+func (application *application) removeUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	application.changeUserRoles(w, r, data.AuditRolesRemoved, func(tx data.Models, userID int64, names []string) error {
+		return tx.Roles.RemoveForUser(userID, names...)
+	})
+}
+
+func (application *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	application.changeUserPermissions(w, r, data.AuditPermissionsGranted, func(tx data.Models, userID int64, codes []string) error {
+		return tx.Permissions.AddForUser(userID, codes...)
+	})
+}
+
+// revokeUserPermissionsHandler revokes permissions that were granted to the user
+// directly. Permissions that come from one of the user's roles can only be taken away
+// by removing the role.
+func (application *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	application.changeUserPermissions(w, r, data.AuditPermissionsRevoked, func(tx data.Models, userID int64, codes []string) error {
+		return tx.Permissions.RemoveForUser(userID, codes...)
+	})
+}
+
+func (application *application) changeUserRoles(w http.ResponseWriter, r *http.Request, action string, change func(tx data.Models, userID int64, names []string) error) {
+	user, ok := application.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	roles, err := application.models.Roles.GetAll()
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	known := make([]string, len(roles))
+	for i, role := range roles {
+		known[i] = role.Name
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) >= 1, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+
+	for _, name := range input.Roles {
+		v.Check(validator.PermittedValue(name, known...), "roles", "must only contain existing roles")
+	}
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	application.changeUserAccess(w, r, user, "roles", action, input.Roles, func(tx data.Models) error {
+		return change(tx, user.ID, input.Roles)
+	})
+}
+
+func (application *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, action string, change func(tx data.Models, userID int64, codes []string) error) {
+	user, ok := application.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions data.Permissions `json:"permissions"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := application.models.Permissions.GetAll()
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+
+	if data.ValidatePermissionCodes(v, "permissions", input.Permissions, known); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	application.changeUserAccess(w, r, user, "permissions", action, input.Permissions, func(tx data.Models) error {
+		return change(tx, user.ID, input.Permissions)
+	})
+}
+
+// changeUserAccess applies a change to the user's roles or permissions, audits it as
+// action and responds with the updated account. Signed access tokens carry the
+// permissions they were issued with, so granted access reaches the user's requests at
+// their next refresh, while removing access revokes the tokens to force one.
+func (application *application) changeUserAccess(w http.ResponseWriter, r *http.Request, user *data.User, field, action string, values []string, change func(tx data.Models) error) {
+	err := application.models.Transaction(func(tx data.Models) error {
+		err := change(tx)
+		if err != nil {
+			return err
+		}
+
+		if user.ID != application.contextGetUser(r).ID {
+			return nil
+		}
+
+		permissions, err := tx.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		if !permissions.Include("users:admin") {
+			return errAdminLockout
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errAdminLockout):
+			v := validator.New()
+			v.AddError(field, "you can't remove your own users:admin permission")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	if action == data.AuditRolesRemoved || action == data.AuditPermissionsRevoked {
+		err = application.revokeUserSignedTokens(user.ID)
+		if err != nil {
+			application.dataErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	application.auditUser(r, action, user.ID, map[string]string{field: strings.Join(values, ",")})
+
+	account, err := application.newAccountResponse(user)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"user": account}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
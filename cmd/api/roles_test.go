@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestChangeUserAccess(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	admin := insertTestAdmin(t, application, "access-admin@example.com")
+	adminToken := bearer(signIn(t, routes, admin.Email, false).string("authentication_token", "token"))
+
+	user := insertTestUser(t, application, "access-user@example.com", true)
+	userToken := bearer(signIn(t, routes, user.Email, false).string("authentication_token", "token"))
+
+	roles := fmt.Sprintf("/api/v1/admin/users/%d/roles", user.ID)
+	permissions := fmt.Sprintf("/api/v1/admin/users/%d/permissions", user.ID)
+
+	// The cases run in order against the one user. want and wantMissing are checked
+	// against the user's effective permissions afterwards.
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        map[string]any
+		as          func(r *http.Request)
+		wantStatus  int
+		want        []string
+		wantMissing []string
+	}{
+		{name: "without users:admin", method: http.MethodPost, path: permissions, body: map[string]any{"permissions": []string{"movies:write"}}, as: userToken, wantStatus: http.StatusForbidden, wantMissing: []string{"movies:write"}},
+		{name: "no permissions", method: http.MethodPost, path: permissions, body: map[string]any{"permissions": []string{}}, as: adminToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown permission", method: http.MethodPost, path: permissions, body: map[string]any{"permissions": []string{"movies:fly"}}, as: adminToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "grant", method: http.MethodPost, path: permissions, body: map[string]any{"permissions": []string{"movies:write"}}, as: adminToken, wantStatus: http.StatusOK, want: []string{"movies:write"}},
+		{name: "revoke", method: http.MethodDelete, path: permissions, body: map[string]any{"permissions": []string{"movies:write"}}, as: adminToken, wantStatus: http.StatusOK, wantMissing: []string{"movies:write"}},
+		{name: "no roles", method: http.MethodPost, path: roles, body: map[string]any{"roles": []string{}}, as: adminToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown role", method: http.MethodPost, path: roles, body: map[string]any{"roles": []string{"critic"}}, as: adminToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "duplicate roles", method: http.MethodPost, path: roles, body: map[string]any{"roles": []string{"editor", "editor"}}, as: adminToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "assign role", method: http.MethodPost, path: roles, body: map[string]any{"roles": []string{"editor"}}, as: adminToken, wantStatus: http.StatusOK, want: []string{"movies:read", "movies:write"}},
+		{name: "revoke a permission from a role", method: http.MethodDelete, path: permissions, body: map[string]any{"permissions": []string{"movies:write"}}, as: adminToken, wantStatus: http.StatusOK, want: []string{"movies:write"}},
+		{name: "remove role", method: http.MethodDelete, path: roles, body: map[string]any{"roles": []string{"editor"}}, as: adminToken, wantStatus: http.StatusOK, wantMissing: []string{"movies:read", "movies:write"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, routes, tt.method, tt.path, tt.body, tt.as)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			got, err := application.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, code := range tt.want {
+				if !got.Include(code) {
+					t.Errorf("got permissions %v; want %s", got, code)
+				}
+			}
+
+			for _, code := range tt.wantMissing {
+				if got.Include(code) {
+					t.Errorf("got permissions %v; want %s gone", got, code)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCantRemoveOwnAccess(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	admin := insertTestAdmin(t, application, "self-lockout@example.com")
+	adminToken := bearer(signIn(t, routes, admin.Email, false).string("authentication_token", "token"))
+
+	res := send(t, routes, http.MethodDelete, fmt.Sprintf("/api/v1/admin/users/%d/roles", admin.ID), map[string]any{"roles": []string{"admin"}}, adminToken)
+	if res.StatusCode != http.StatusUnprocessableEntity || res.string("error", "roles") == "" {
+		t.Fatalf("got status %d (%v); want a validation error for roles", res.StatusCode, res.body)
+	}
+
+	permissions, err := application.models.Permissions.GetAllForUser(admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !permissions.Include("users:admin") {
+		t.Errorf("the admin lost users:admin: %v", permissions)
+	}
+}
+
+func TestCreateRole(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	admin := insertTestAdmin(t, application, "create-role@example.com")
+	adminToken := bearer(signIn(t, routes, admin.Email, false).string("authentication_token", "token"))
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+		wantError  string
+	}{
+		{name: "created", body: map[string]any{"name": "critic", "permissions": []string{"movies:read"}}, wantStatus: http.StatusCreated},
+		{name: "duplicate name", body: map[string]any{"name": "critic", "permissions": []string{"movies:read"}}, wantStatus: http.StatusUnprocessableEntity, wantError: "name"},
+		{name: "invalid name", body: map[string]any{"name": "Critic!", "permissions": []string{"movies:read"}}, wantStatus: http.StatusUnprocessableEntity, wantError: "name"},
+		{name: "unknown permission", body: map[string]any{"name": "reviewer", "permissions": []string{"movies:fly"}}, wantStatus: http.StatusUnprocessableEntity, wantError: "permissions"},
+		{name: "missing permissions", body: map[string]any{"name": "reviewer"}, wantStatus: http.StatusUnprocessableEntity, wantError: "permissions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, routes, http.MethodPost, "/api/v1/admin/roles", tt.body, adminToken)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			if tt.wantError != "" && res.string("error", tt.wantError) == "" {
+				t.Errorf("got %v; want an error for %q", res.body, tt.wantError)
+			}
+		})
+	}
+
+	role, err := application.models.Roles.Get("critic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(role.Permissions) != 1 || role.Permissions[0] != "movies:read" {
+		t.Errorf("got role permissions %v; want [movies:read]", role.Permissions)
+	}
+}
@@ -21,15 +21,58 @@ func (application *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/api/v1/movies/:id", application.requirePermission("movies:read", application.showMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/api/v1/movies/:id", application.requirePermission("movies:write", application.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/api/v1/movies/:id", application.requirePermission("movies:write", application.deleteMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/apikeys", application.requirePermission("apikeys:manage", application.requireUserSession(application.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/api/v1/apikeys", application.requirePermission("apikeys:manage", application.requireUserSession(application.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/apikeys/:id", application.requirePermission("apikeys:manage", application.requireUserSession(application.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/api/v1/permissions", application.requireActivatedUser(application.listPermissionsHandler))
+
+	// Admin Routes
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/users", application.requirePermission("users:admin", application.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/users/:id", application.requirePermission("users:admin", application.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/api/v1/admin/users/:id", application.requirePermission("users:admin", application.updateUserHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/users/:id/password-reset", application.requirePermission("users:admin", application.forcePasswordResetHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/users/:id/tokens", application.requirePermission("users:admin", application.revokeUserTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/users/:id/roles", application.requirePermission("users:admin", application.addUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/users/:id/roles", application.requirePermission("users:admin", application.removeUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/users/:id/permissions", application.requirePermission("users:admin", application.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/admin/users/:id/permissions", application.requirePermission("users:admin", application.revokeUserPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/roles", application.requirePermission("users:admin", application.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/roles", application.requirePermission("users:admin", application.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/roles/:name", application.requirePermission("users:admin", application.showRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/api/v1/admin/invitations", application.requirePermission("users:admin", application.createInvitationHandler))
+	router.HandlerFunc(http.MethodGet, "/api/v1/admin/audit", application.requirePermission("audit:read", application.listAuditEventsHandler))
 
 	// User Routes
 	router.HandlerFunc(http.MethodPost, "/users", application.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/users/activate", application.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/users/password", application.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/users/email", application.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/users/me", application.requireCurrentUser(application.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/users/me", application.requireCurrentUser(application.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/users/me", application.requireCurrentUser(application.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/users/me/export", application.requireCurrentUser(application.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/users/me/sessions", application.requireCurrentUser(application.listCurrentUserSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/users/me/sessions/:id", application.requireCurrentUser(application.deleteCurrentUserSessionHandler))
+	router.HandlerFunc(http.MethodPut, "/users/me/password", application.requireCurrentUser(application.updateCurrentUserPasswordHandler))
+	router.HandlerFunc(http.MethodPut, "/users/me/email", application.requireCurrentUser(application.requestEmailChangeHandler))
+	router.HandlerFunc(http.MethodGet, "/users/me/mfa", application.requireCurrentUser(application.showMFAHandler))
+	router.HandlerFunc(http.MethodPost, "/users/me/mfa", application.requireCurrentUser(application.enrollMFAHandler))
+	router.HandlerFunc(http.MethodDelete, "/users/me/mfa", application.requireCurrentUser(application.disableMFAHandler))
+	router.HandlerFunc(http.MethodPut, "/users/me/mfa/confirm", application.requireCurrentUser(application.confirmMFAHandler))
+	router.HandlerFunc(http.MethodPost, "/users/me/mfa/recovery-codes", application.requireCurrentUser(application.regenerateRecoveryCodesHandler))
 
 	// Token Routes
 	router.HandlerFunc(http.MethodPost, "/tokens/authentication", application.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/tokens/authentication/mfa", application.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/tokens/authentication/magic-link", application.createMagicLinkAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/tokens/authentication", application.requireUserSession(application.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/tokens/authentication/all", application.requireUserSession(application.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/tokens/refresh", application.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/tokens/activation", application.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/tokens/password-reset", application.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/tokens/magic-link", application.createMagicLinkTokenHandler)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return application.metrics(application.recoverPanic(application.enableCORS(application.rateLimiter(application.authenticate(router)))))
+	return application.metrics(application.requestID(application.recoverPanic(application.enableCORS(application.rateLimiter(application.authenticate(router))))))
 }
@@ -45,10 +45,22 @@ func (application *application) serve() error {
 			"addr": server.Addr,
 		})
 
+		close(application.shutdown)
 		application.wg.Wait()
 		shutdownError <- nil
 	}()
 
+	application.runPeriodically("purge_deleted_users", time.Hour, application.purgeDeletedUsers)
+	application.runPeriodically("purge_login_attempts", time.Hour, application.purgeLoginAttempts)
+
+	if application.config.auth.sweepInterval > 0 {
+		application.runPeriodically("sweep_expired_tokens", application.config.auth.sweepInterval, application.sweepExpiredTokens)
+	}
+
+	if application.signer != nil {
+		application.runPeriodically("refresh_revocations", 30*time.Second, application.refreshRevocations)
+	}
+
 	application.log.PrintInfo("server starting", map[string]string{
 		"host":        "127.0.0.1",
 		"port":        server.Addr,
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"greenlight.badrchoubai.dev/internal/data"
+	"net/http"
+	"time"
+)
+
+// Cookie sessions let browser clients keep their tokens out of reach of JavaScript.
+// The __Host- and __Secure- prefixes stop the cookies from being set by other
+// subdomains or over plain HTTP, which is what makes the double-submit CSRF check
+// sound: a request only passes if its X-CSRF-Token header repeats the CSRF cookie,
+// which a cross-site page can neither read nor overwrite.
+const (
+	sessionCookieName = "__Host-greenlight_session"
+	refreshCookieName = "__Secure-greenlight_refresh"
+	csrfCookieName    = "__Host-greenlight_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+
+	refreshCookiePath = "/tokens/refresh"
+)
+
+type sessionResponse struct {
+	Expiry        time.Time `json:"expiry"`
+	RefreshExpiry time.Time `json:"refresh_expiry"`
+	CSRFToken     string    `json:"csrf_token"`
+}
+
+// writeSessionCookies stores the tokens in HttpOnly cookies, along with a new CSRF token
+// that the client has to send back in the X-CSRF-Token header.
+func (application *application) writeSessionCookies(w http.ResponseWriter, accessToken, refreshToken *data.Token) (*sessionResponse, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &sessionResponse{
+		Expiry:        accessToken.Expiry,
+		RefreshExpiry: refreshToken.Expiry,
+		CSRFToken:     base64.RawURLEncoding.EncodeToString(randomBytes),
+	}
+
+	http.SetCookie(w, application.sessionCookie(sessionCookieName, "/", accessToken.Plaintext, accessToken.Expiry, true))
+	http.SetCookie(w, application.sessionCookie(refreshCookieName, refreshCookiePath, refreshToken.Plaintext, refreshToken.Expiry, true))
+	http.SetCookie(w, application.sessionCookie(csrfCookieName, "/", session.CSRFToken, refreshToken.Expiry, false))
+
+	return session, nil
+}
+
+// clearSessionCookies tells the browser to drop the session cookies if the request came
+// with any of them. The refresh cookie is only sent to its own path, so it is dropped
+// whenever the others are.
+func (application *application) clearSessionCookies(w http.ResponseWriter, r *http.Request) {
+	cookies := []struct{ name, path string }{
+		{sessionCookieName, "/"},
+		{refreshCookieName, refreshCookiePath},
+		{csrfCookieName, "/"},
+	}
+
+	present := false
+	for _, cookie := range cookies {
+		if _, err := r.Cookie(cookie.name); err == nil {
+			present = true
+			break
+		}
+	}
+
+	if !present {
+		return
+	}
+
+	for _, cookie := range cookies {
+		http.SetCookie(w, application.sessionCookie(cookie.name, cookie.path, "", time.Time{}, true))
+	}
+}
+
+// sessionCookie returns one of the session cookies. A zero expiry deletes it.
+func (application *application) sessionCookie(name, path, value string, expiry time.Time, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Secure:   true,
+		HttpOnly: httpOnly,
+		SameSite: application.config.auth.cookieSameSite,
+		MaxAge:   -1,
+	}
+
+	if !expiry.IsZero() {
+		cookie.MaxAge = int(time.Until(expiry).Seconds())
+	}
+
+	return cookie
+}
+
+// validCSRFToken reports whether the request may go ahead on the strength of its
+// session cookies. Safe methods always may; anything else needs the X-CSRF-Token header
+// to match the CSRF cookie.
+func (application *application) validCSRFToken(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	header := r.Header.Get(csrfHeaderName)
+
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
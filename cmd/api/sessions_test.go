@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCookieSessionCSRF(t *testing.T) {
+	application, routes := newTestApplication(t)
+	user := insertTestUser(t, application, "csrf@example.com", true)
+
+	session := signIn(t, routes, "csrf@example.com", true)
+
+	if session.string("authentication_token", "token") != "" {
+		t.Fatal("a cookie session returned its tokens in the body")
+	}
+
+	csrfToken := session.string("session", "csrf_token")
+	sessionCookie := session.cookie(sessionCookieName)
+	refreshCookie := session.cookie(refreshCookieName)
+	csrfCookie := session.cookie(csrfCookieName)
+
+	if csrfToken == "" || sessionCookie == nil || refreshCookie == nil || csrfCookie == nil {
+		t.Fatalf("missing session cookies or CSRF token: %v", session.body)
+	}
+
+	if !sessionCookie.HttpOnly || !refreshCookie.HttpOnly || csrfCookie.HttpOnly {
+		t.Error("only the CSRF cookie should be readable by scripts")
+	}
+
+	// withCookies sends the session cookies, and header as the X-CSRF-Token header
+	// unless it is empty.
+	withCookies := func(header string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionCookie.Value})
+			r.AddCookie(&http.Cookie{Name: refreshCookieName, Value: refreshCookie.Value})
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: csrfCookie.Value})
+			if header != "" {
+				r.Header.Set(csrfHeaderName, header)
+			}
+		}
+	}
+
+	// withoutCSRFCookie is what a cross-site form post looks like: the browser sends
+	// the session cookie, but the page can't read the CSRF cookie to echo it.
+	withoutCSRFCookie := func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionCookie.Value})
+		r.Header.Set(csrfHeaderName, csrfToken)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		prepare    func(r *http.Request)
+		wantStatus int
+	}{
+		{name: "safe method without header", method: http.MethodGet, path: "/users/me", prepare: withCookies(""), wantStatus: http.StatusOK},
+		{name: "unsafe method without header", method: http.MethodPatch, path: "/users/me", body: map[string]any{"name": "Changed", "version": user.Version}, prepare: withCookies(""), wantStatus: http.StatusForbidden},
+		{name: "unsafe method with wrong header", method: http.MethodPatch, path: "/users/me", body: map[string]any{"name": "Changed", "version": user.Version}, prepare: withCookies("wrong"), wantStatus: http.StatusForbidden},
+		{name: "unsafe method without CSRF cookie", method: http.MethodPatch, path: "/users/me", body: map[string]any{"name": "Changed", "version": user.Version}, prepare: withoutCSRFCookie, wantStatus: http.StatusForbidden},
+		{name: "unsafe method with header", method: http.MethodPatch, path: "/users/me", body: map[string]any{"name": "Changed", "version": user.Version}, prepare: withCookies(csrfToken), wantStatus: http.StatusOK},
+		{name: "refresh without header", method: http.MethodPost, path: "/tokens/refresh", prepare: withCookies(""), wantStatus: http.StatusForbidden},
+		{name: "refresh with wrong header", method: http.MethodPost, path: "/tokens/refresh", prepare: withCookies("wrong"), wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, routes, tt.method, tt.path, tt.body, tt.prepare)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+		})
+	}
+
+	// The refresh cookie alone rotates the session once the header matches, and the
+	// CSRF token changes along with it.
+	refreshed := send(t, routes, http.MethodPost, "/tokens/refresh", nil, withCookies(csrfToken))
+	if refreshed.StatusCode != http.StatusCreated {
+		t.Fatalf("refresh with header: got status %d; want %d (%v)", refreshed.StatusCode, http.StatusCreated, refreshed.body)
+	}
+
+	if newToken := refreshed.string("session", "csrf_token"); newToken == "" || newToken == csrfToken {
+		t.Errorf("refreshing didn't issue a new CSRF token: %v", refreshed.body)
+	}
+
+	if refreshed.cookie(sessionCookieName) == nil || refreshed.cookie(refreshCookieName) == nil {
+		t.Error("refreshing didn't set new session cookies")
+	}
+}
+
+func TestCookieSessionsDisabled(t *testing.T) {
+	application, routes := newTestApplication(t)
+	insertTestUser(t, application, "no-cookies@example.com", true)
+
+	application.config.auth.cookieSessions = false
+	t.Cleanup(func() { application.config.auth.cookieSessions = true })
+
+	res := send(t, routes, http.MethodPost, "/tokens/authentication", map[string]any{
+		"email":    "no-cookies@example.com",
+		"password": testPassword,
+		"cookie":   true,
+	}, nil)
+
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d; want %d (%v)", res.StatusCode, http.StatusUnprocessableEntity, res.body)
+	}
+}
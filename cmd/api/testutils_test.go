@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"golang.org/x/time/rate"
+	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/jsonlog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testPassword = "pa55word"
+
+var (
+	testApplication     *application
+	testMailer          = &recordingMailer{}
+	testRoutes          http.Handler
+	testApplicationOnce sync.Once
+)
+
+// sentMail is an email the application asked to send.
+type sentMail struct {
+	recipient    string
+	templateFile string
+	data         any
+}
+
+// recordingMailer keeps the emails it is asked to send instead of sending them.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []sentMail
+}
+
+func (m *recordingMailer) Send(recipient, templateFile string, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, sentMail{recipient: recipient, templateFile: templateFile, data: data})
+	return nil
+}
+
+// sentTo returns the emails sent to recipient using templateFile.
+func (m *recordingMailer) sentTo(recipient, templateFile string) []sentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sent []sentMail
+	for _, mail := range m.sent {
+		if mail.recipient == recipient && mail.templateFile == templateFile {
+			sent = append(sent, mail)
+		}
+	}
+	return sent
+}
+
+// newTestApplication returns an application backed by the in-memory store and
+// testMailer, with cookie sessions on and the rate limiter and auth cache off. routes
+// publishes expvars, which can only happen once per process, so every test shares the
+// one application and keeps out of the others' way by signing in as its own users.
+func newTestApplication(t *testing.T) (*application, http.Handler) {
+	t.Helper()
+
+	testApplicationOnce.Do(func() {
+		err := data.SetPasswordParams(data.PasswordParams{
+			Algorithm:         data.PasswordAlgorithmArgon2id,
+			Argon2Memory:      64,
+			Argon2Iterations:  1,
+			Argon2Parallelism: 1,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		var cfg config
+		cfg.env = "development"
+		cfg.db.dsn = "memory://"
+		cfg.account.registrationMode = "open"
+		cfg.account.invitationTTL = 7 * 24 * time.Hour
+		cfg.auth.tokenMode = "opaque"
+		cfg.auth.accessTokenTTL = 15 * time.Minute
+		cfg.auth.refreshTokenTTL = 24 * time.Hour
+		cfg.auth.cookieSessions = true
+		cfg.auth.cookieSameSite = http.SameSiteLaxMode
+		cfg.login.window = 15 * time.Minute
+		cfg.login.delayAfter = 100
+		cfg.login.lockoutThreshold = 100
+		cfg.login.ipLockoutThreshold = 100
+		cfg.login.lockoutDuration = 15 * time.Minute
+
+		logger := jsonlog.New(io.Discard, jsonlog.LevelOff)
+
+		mfaCipher, err := newMFACipher(cfg, logger)
+		if err != nil {
+			panic(err)
+		}
+
+		testApplication = &application{
+			config:           cfg,
+			log:              logger,
+			models:           data.NewMemoryModels(),
+			mailer:           testMailer,
+			shutdown:         make(chan struct{}),
+			activationEmails: newKeyedLimiter(rate.Every(10*time.Minute), 3),
+			magicLinkEmails:  newKeyedLimiter(rate.Every(10*time.Minute), 3),
+			revocations:      newRevocationList(),
+			mfaCipher:        mfaCipher,
+			mfaAttempts:      newKeyedLimiter(rate.Every(time.Minute), 5),
+			auditFailures:    new(expvar.Int),
+			tokenSweeps:      new(expvar.Map).Init(),
+		}
+
+		testRoutes = testApplication.routes()
+	})
+
+	// Audit events are written in the background; let them land before the next test.
+	t.Cleanup(testApplication.wg.Wait)
+
+	return testApplication, testRoutes
+}
+
+// insertTestUser adds a user with testPassword to the application's store.
+func insertTestUser(t *testing.T, application *application, email string, activated bool) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: activated}
+
+	err := user.Password.Set(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = application.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// insertTestAdmin adds a user with the admin role.
+func insertTestAdmin(t *testing.T, application *application, email string) *data.User {
+	t.Helper()
+
+	user := insertTestUser(t, application, email, true)
+
+	err := application.models.Roles.AddForUser(user.ID, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// insertTestAPIKey adds an API key for the user and returns its plaintext.
+func insertTestAPIKey(t *testing.T, application *application, userID int64, permissions ...string) string {
+	t.Helper()
+
+	key, err := data.GenerateAPIKey(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key.Name = "test"
+	key.Permissions = permissions
+
+	err = application.models.APIKeys.Insert(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.Plaintext
+}
+
+// testResponse is a recorded response with its JSON body decoded.
+type testResponse struct {
+	*http.Response
+	body map[string]any
+}
+
+// string returns the field at path in the response body, or "" if there isn't one.
+func (res *testResponse) string(path ...string) string {
+	var value any = res.body
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+
+	s, _ := value.(string)
+	return s
+}
+
+// cookie returns the named cookie the response set, or nil.
+func (res *testResponse) cookie(name string) *http.Cookie {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// send makes a request against routes. body, if not nil, is sent as JSON, and prepare
+// can add headers and cookies.
+func send(t *testing.T, routes http.Handler, method, path string, body any, prepare func(r *http.Request)) *testResponse {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if prepare != nil {
+		prepare(r)
+	}
+
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, r)
+
+	res := &testResponse{Response: rr.Result()}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &res.body)
+		if err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, b, err)
+		}
+	}
+
+	return res
+}
+
+// bearer returns a prepare function for send that authenticates with token.
+func bearer(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// apiKey returns a prepare function for send that authenticates with an API key.
+func apiKey(key string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "ApiKey "+key)
+	}
+}
+
+// signIn signs the user in with their password and returns the response.
+func signIn(t *testing.T, routes http.Handler, email string, cookie bool) *testResponse {
+	t.Helper()
+
+	res := send(t, routes, http.MethodPost, "/tokens/authentication", map[string]any{
+		"email":    email,
+		"password": testPassword,
+		"cookie":   cookie,
+	}, nil)
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("signing in %s: got status %d; want %d (%v)", email, res.StatusCode, http.StatusCreated, res.body)
+	}
+
+	return res
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyedLimiter rate limits actions per key (an email address for example) rather than
+// per client IP.
+type keyedLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+func newKeyedLimiter(limit rate.Limit, burst int) *keyedLimiter {
+	kl := &keyedLimiter{
+		limit:    limit,
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			kl.mu.Lock()
+
+			// A limiter that has refilled completely behaves the same as a fresh one, so
+			// it can be dropped.
+			for key, limiter := range kl.limiters {
+				if limiter.Tokens() >= float64(kl.burst) {
+					delete(kl.limiters, key)
+				}
+			}
+			kl.mu.Unlock()
+		}
+	}()
+
+	return kl
+}
+
+// Allow reports whether an action for key may happen now. Keys are compared case
+// insensitively, matching how emails are stored.
+func (kl *keyedLimiter) Allow(key string) bool {
+	key = strings.ToLower(key)
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	limiter, found := kl.limiters[key]
+	if !found {
+		limiter = rate.NewLimiter(kl.limit, kl.burst)
+		kl.limiters[key] = limiter
+	}
+
+	return limiter.Allow()
+}
+
+// hashSlots bounds how many password hashes run at once. Argon2id takes tens of
+// megabytes for every hash, so without a bound a flood of sign ins or registrations
+// could take all the server's memory. A nil hashSlots doesn't limit anything.
+type hashSlots chan struct{}
+
+func newHashSlots(n int) hashSlots {
+	if n < 1 {
+		return nil
+	}
+
+	return make(hashSlots, n)
+}
+
+// acquire waits for a free slot, giving up if ctx is done first. The function it
+// returns frees the slot again.
+func (s hashSlots) acquire(ctx context.Context) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHashSlots(t *testing.T) {
+	slots := newHashSlots(1)
+
+	release, err := slots.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := slots.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v while every slot was taken; want the context's error", err)
+	}
+
+	release()
+
+	release, err = slots.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("got %v after the slot was freed", err)
+	}
+	release()
+
+	// Without a limit nothing ever waits.
+	var unlimited hashSlots
+	for i := 0; i < 3; i++ {
+		if _, err := unlimited.acquire(ctx); err != nil {
+			t.Fatalf("got %v without a limit", err)
+		}
+	}
+}
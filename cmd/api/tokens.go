@@ -3,8 +3,10 @@ package main
 import (
 	"errors"
 	"greenlight.badrchoubai.dev/internal/data"
+	"greenlight.badrchoubai.dev/internal/signedtoken"
 	"greenlight.badrchoubai.dev/internal/validator"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -12,6 +14,7 @@ func (application *application) createAuthenticationTokenHandler(w http.Response
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Cookie   bool   `json:"cookie"`
 	}
 
 	err := application.readJSON(w, r, &input)
@@ -22,50 +25,656 @@ func (application *application) createAuthenticationTokenHandler(w http.Response
 
 	v := validator.New()
 	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateSignInPassword(v, input.Password)
+	application.validateCookieRequest(v, input.Cookie)
 
 	if !v.Valid() {
 		application.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	ip := application.clientIP(r)
+
+	throttle, err := application.loginThrottle(input.Email, ip)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter := time.Until(throttle.lockedUntil); retryAfter > 0 {
+		application.audit(r, &data.AuditEvent{
+			Action:  data.AuditLoginFailed,
+			Details: map[string]string{"email": input.Email, "reason": "locked_out"},
+		})
+
+		application.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
+	if throttle.delay > 0 {
+		select {
+		case <-time.After(throttle.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
 	user, err := application.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			application.invalidCredentialsResponse(w, r)
+			application.loginFailed(w, r, input.Email, ip, nil)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	release, err := application.passwordHashes.acquire(r.Context())
+	if err != nil {
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
+	release()
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
 		return
 	}
 
 	if !match {
-		application.invalidCredentialsResponse(w, r)
+		application.loginFailed(w, r, input.Email, ip, user)
+		return
+	}
+
+	err = application.models.LoginAttempts.DeleteForEmail(input.Email)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// Hashes made with an older algorithm or cost are replaced while the plaintext is
+	// at hand. Failing to do so doesn't stop the sign in; it is retried next time.
+	if user.Password.NeedsRehash() {
+		release, err = application.passwordHashes.acquire(r.Context())
+		if err == nil {
+			err = user.Password.Set(input.Password)
+			release()
+		}
+		if err == nil {
+			err = application.models.Users.Update(user)
+		}
+		if err == nil {
+			application.invalidateAuthCache(user.ID)
+		} else if !errors.Is(err, data.ErrEditConflict) {
+			application.logError(r, err)
+		}
+	}
+
+	application.signInOrChallenge(w, r, user, input.Cookie)
+}
+
+// signInOrChallenge signs the user in once their first factor, a password or a magic
+// link, has been checked. With two-factor authentication on, the first factor only
+// earns a short-lived challenge token, which createMFAAuthenticationTokenHandler
+// exchanges for the real tokens once a code has been checked.
+func (application *application) signInOrChallenge(w http.ResponseWriter, r *http.Request, user *data.User, cookie bool) {
+	if user.IsDisabled() {
+		application.disabledSignIn(w, r, user)
+		return
+	}
+
+	mfa, err := application.models.MFA.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	if mfa != nil && mfa.Enabled() {
+		token, err := application.models.Token.New(user.ID, 5*time.Minute, data.ScopeMFAPending)
+		if err != nil {
+			application.dataErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{
+			"mfa_token": token,
+			"message":   "enter a code from your authenticator app or one of your recovery codes",
+		}
+
+		err = application.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			application.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.signIn(w, r, user, cookie)
+}
+
+// signIn completes a sign in once every factor has been checked, responding with a new
+// pair of access and refresh tokens, or setting them as cookies when cookie is true.
+func (application *application) signIn(w http.ResponseWriter, r *http.Request, user *data.User, cookie bool) {
+	if user.IsDisabled() {
+		application.disabledSignIn(w, r, user)
 		return
 	}
 
-	token, err := application.models.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Signing in during the grace period cancels a pending account deletion.
+	if user.ScheduledDeletionAt != nil {
+		user.ScheduledDeletionAt = nil
+
+		err := application.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				application.editConflictResponse(w, r)
+			default:
+				application.dataErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		application.invalidateAuthCache(user.ID)
+
+		application.auditUser(r, data.AuditDeletionCanceled, user.ID, nil)
+	}
+
+	family, err := data.NewTokenFamily()
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = application.writeJSON(
-		w,
-		http.StatusCreated,
-		envelope{"authentication_token": token},
-		nil,
-	)
+	var accessToken, refreshToken *data.Token
 
+	err = application.models.Transaction(func(tx data.Models) error {
+		var err error
+		accessToken, refreshToken, err = application.issueAuthenticationTokens(tx, r, user, family)
+		return err
+	})
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditLoginSucceeded, user.ID, nil)
+
+	application.writeAuthenticationTokens(w, r, accessToken, refreshToken, cookie)
+}
+
+// disabledSignIn refuses to sign in a user whose account has been disabled, once they
+// have proved who they are.
+func (application *application) disabledSignIn(w http.ResponseWriter, r *http.Request, user *data.User) {
+	application.auditUser(r, data.AuditLoginFailed, user.ID, map[string]string{"reason": "disabled"})
+	application.disabledAccountResponse(w, r)
+}
+
+// writeAuthenticationTokens responds with a newly issued pair of tokens, either in the
+// body or, for cookie sessions, as cookies.
+func (application *application) writeAuthenticationTokens(w http.ResponseWriter, r *http.Request, accessToken, refreshToken *data.Token, cookie bool) {
+	env := envelope{"authentication_token": accessToken, "refresh_token": refreshToken}
+
+	if cookie {
+		session, err := application.writeSessionCookies(w, accessToken, refreshToken)
+		if err != nil {
+			application.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env = envelope{"session": session}
+	}
+
+	err := application.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateCookieRequest checks that a client asking for a cookie session can have one.
+func (application *application) validateCookieRequest(v *validator.Validator, cookie bool) {
+	v.Check(!cookie || application.config.auth.cookieSessions, "cookie", "cookie sessions are not enabled")
+}
+
+// issueAuthenticationTokens creates a short-lived access token and the refresh token
+// that can replace it, both belonging to the given token family. In signed mode the
+// access token isn't stored; only the refresh token is.
+func (application *application) issueAuthenticationTokens(models data.Models, r *http.Request, user *data.User, family string) (*data.Token, *data.Token, error) {
+	var tokens [2]*data.Token
+
+	for i, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		if scope == data.ScopeAuthentication && application.signer != nil {
+			token, err := application.signAccessToken(models, user, family)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			tokens[i] = token
+			continue
+		}
+
+		ttl := application.config.auth.accessTokenTTL
+		if scope == data.ScopeRefresh {
+			ttl = application.config.auth.refreshTokenTTL
+		}
+
+		token, err := data.GenerateToken(user.ID, ttl, scope)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		token.Family = family
+		token.UserAgent = truncate(r.UserAgent(), 256)
+		token.IP = application.clientIP(r)
+
+		err = models.Token.Insert(token)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tokens[i] = token
+	}
+
+	return tokens[0], tokens[1], nil
+}
+
+// signAccessToken creates a signed access token carrying the user's permissions as they
+// are now. Changes to them only reach the client when the token is refreshed, which is
+// why signed access tokens should be kept short-lived.
+func (application *application) signAccessToken(models data.Models, user *data.User, family string) (*data.Token, error) {
+	permissions, err := models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := signedtoken.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(application.config.auth.accessTokenTTL)
+
+	plaintext, err := application.signer.Sign(signedtoken.Claims{
+		ID:          id,
+		Subject:     user.ID,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Family:      family,
+		IssuedAt:    now.Unix(),
+		Expiry:      expiry.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    time.Unix(expiry.Unix(), 0),
+		Scope:     data.ScopeAuthentication,
+	}, nil
+}
+
+// refreshAuthenticationTokenHandler rotates a refresh token sent in the body or, for
+// cookie sessions, in the refresh cookie. An empty body is allowed in the latter case.
+func (application *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	cookie, err := r.Cookie(refreshCookieName)
+	fromCookie := err == nil && application.config.auth.cookieSessions
+
+	if r.ContentLength != 0 || !fromCookie {
+		err := application.readJSON(w, r, &input)
+		if err != nil {
+			application.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	if input.RefreshToken != "" {
+		fromCookie = false
+	} else if fromCookie {
+		if !application.validCSRFToken(r) {
+			application.invalidCSRFTokenResponse(w, r)
+			return
+		}
+
+		input.RefreshToken = cookie.Value
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := application.models.Token.Get(data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if fromCookie {
+				application.clearSessionCookies(w, r)
+			}
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := application.models.Users.Get(refreshToken.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
 		return
 	}
+
+	if user.IsDisabled() {
+		application.disabledSignIn(w, r, user)
+		return
+	}
+
+	var accessToken, newRefreshToken *data.Token
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Token.MarkUsed(data.ScopeRefresh, input.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		// Only the newest access token of a family stays valid.
+		err = tx.Token.DeleteForFamily(data.ScopeAuthentication, refreshToken.Family)
+		if err != nil {
+			return err
+		}
+
+		accessToken, newRefreshToken, err = application.issueAuthenticationTokens(tx, r, user, refreshToken.Family)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.refreshTokenReused(w, r, refreshToken)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	application.auditUser(r, data.AuditTokenCreated, user.ID, map[string]string{"reason": "refresh", "family": refreshToken.Family})
+
+	application.writeAuthenticationTokens(w, r, accessToken, newRefreshToken, fromCookie)
+}
+
+// refreshTokenReused handles a refresh token being presented after it was rotated.
+// Either the client or an attacker holds a stolen copy and there's no telling which, so
+// every token in the family is revoked and the user has to sign in again.
+func (application *application) refreshTokenReused(w http.ResponseWriter, r *http.Request, refreshToken *data.Token) {
+	application.log.PrintInfo("refresh token reuse detected, revoking token family", map[string]string{
+		"user_id": strconv.FormatInt(refreshToken.UserID, 10),
+		"family":  refreshToken.Family,
+		"ip":      application.clientIP(r),
+	})
+
+	err := application.models.Token.DeleteFamily(refreshToken.Family)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.invalidateAuthCache(refreshToken.UserID)
+
+	// The family's signed access token isn't stored, so every signed access token the
+	// user holds is revoked with it. Their other sessions get new ones when they refresh.
+	err = application.revokeUserSignedTokens(refreshToken.UserID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditTokenRevoked, refreshToken.UserID, map[string]string{"reason": "refresh_token_reused", "family": refreshToken.Family})
+
+	application.clearSessionCookies(w, r)
+	application.invalidAuthenticationTokenResponse(w, r)
+}
+
+func (application *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The lookup happens in the background so that the response, and how long it takes,
+	// is the same whether or not an account exists for the email address.
+	application.background(func() {
+		user, err := application.models.Users.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				application.log.PrintError(err, nil)
+			}
+			return
+		}
+
+		token, err := application.models.Token.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			application.log.PrintError(err, nil)
+			return
+		}
+
+		application.auditUser(r, data.AuditTokenCreated, user.ID, map[string]string{"reason": "password_reset"})
+
+		passwordResetInfo := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err = application.mailer.Send(user.Email, "token_password_reset.tmpl", passwordResetInfo)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "if an account exists for this email address you will receive password reset instructions"}
+
+	err = application.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !application.activationEmails.Allow(input.Email) {
+		application.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// Like password resets, the lookup happens in the background so the response doesn't
+	// reveal whether the address has an account or what state it is in. Only accounts
+	// that are neither activated nor disabled are sent a token.
+	application.background(func() {
+		user, err := application.models.Users.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				application.log.PrintError(err, nil)
+			}
+			return
+		}
+
+		if user.Activated || user.IsDisabled() {
+			return
+		}
+
+		var token *data.Token
+
+		err = application.models.Transaction(func(tx data.Models) error {
+			err := tx.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
+			if err != nil {
+				return err
+			}
+
+			token, err = tx.Token.New(user.ID, 24*time.Hour, data.ScopeActivation)
+			return err
+		})
+		if err != nil {
+			application.log.PrintError(err, nil)
+			return
+		}
+
+		activationInfo := map[string]any{
+			"activationToken": token.Plaintext,
+		}
+
+		err = application.mailer.Send(user.Email, "token_activation.tmpl", activationInfo)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "if an inactive account exists for this email address you will receive activation instructions"}
+
+	err = application.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if claims := application.contextGetClaims(r); claims != nil {
+		application.deleteSignedAuthenticationToken(w, r, claims)
+		return
+	}
+
+	tokenPlaintext := application.contextGetToken(r)
+
+	token, err := application.models.Token.Get(data.ScopeAuthentication, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.invalidAuthenticationTokenResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Signing out also revokes the refresh token, otherwise it could be used to sign
+	// straight back in.
+	if token.Family != "" {
+		err = application.models.Token.DeleteFamily(token.Family)
+	} else {
+		err = application.models.Token.Delete(data.ScopeAuthentication, tokenPlaintext)
+	}
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.invalidateAuthCache(token.UserID)
+
+	application.auditUser(r, data.AuditTokenRevoked, token.UserID, map[string]string{"reason": "sign_out", "family": token.Family})
+
+	application.clearSessionCookies(w, r)
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSignedAuthenticationToken signs out of a session whose access token is signed.
+// The access token can't be deleted, so it is deny-listed until it expires, and the
+// refresh tokens of its family are deleted as usual.
+func (application *application) deleteSignedAuthenticationToken(w http.ResponseWriter, r *http.Request, claims *signedtoken.Claims) {
+	err := application.revokeSignedToken(claims.ID, time.Unix(claims.Expiry, 0))
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.models.Token.DeleteFamily(claims.Family)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditTokenRevoked, application.contextGetUser(r).ID, map[string]string{"reason": "sign_out", "family": claims.Family})
+
+	application.clearSessionCookies(w, r)
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	err := application.models.Transaction(func(tx data.Models) error {
+		err := tx.Token.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Token.DeleteAllForUser(data.ScopeRefresh, user.ID)
+	})
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	err = application.revokeUserSignedTokens(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditTokenRevoked, user.ID, map[string]string{"reason": "sign_out_all"})
+
+	application.clearSessionCookies(w, r)
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out of every session"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	application, routes := newTestApplication(t)
+	insertTestUser(t, application, "refresh-reuse@example.com", true)
+
+	first := signIn(t, routes, "refresh-reuse@example.com", false)
+
+	// A second sign in starts another family, which the reuse must leave alone.
+	other := signIn(t, routes, "refresh-reuse@example.com", false)
+
+	refresh := func(token string) *testResponse {
+		return send(t, routes, http.MethodPost, "/tokens/refresh", map[string]any{"refresh_token": token}, nil)
+	}
+
+	me := func(token string) *testResponse {
+		return send(t, routes, http.MethodGet, "/users/me", nil, bearer(token))
+	}
+
+	rotated := refresh(first.string("refresh_token", "token"))
+	if rotated.StatusCode != http.StatusCreated {
+		t.Fatalf("first refresh: got status %d; want %d", rotated.StatusCode, http.StatusCreated)
+	}
+
+	steps := []struct {
+		name       string
+		do         func() *testResponse
+		wantStatus int
+	}{
+		{
+			name:       "old access token is replaced by the rotation",
+			do:         func() *testResponse { return me(first.string("authentication_token", "token")) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "rotated access token works",
+			do:         func() *testResponse { return me(rotated.string("authentication_token", "token")) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "reusing the old refresh token",
+			do:         func() *testResponse { return refresh(first.string("refresh_token", "token")) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "rotated access token is revoked with the family",
+			do:         func() *testResponse { return me(rotated.string("authentication_token", "token")) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "rotated refresh token is revoked with the family",
+			do:         func() *testResponse { return refresh(rotated.string("refresh_token", "token")) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "other family still works",
+			do:         func() *testResponse { return me(other.string("authentication_token", "token")) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "other family can still refresh",
+			do:         func() *testResponse { return refresh(other.string("refresh_token", "token")) },
+			wantStatus: http.StatusCreated,
+		},
+	}
+
+	// The steps depend on each other, so they run in order.
+	for _, step := range steps {
+		if res := step.do(); res.StatusCode != step.wantStatus {
+			t.Errorf("%s: got status %d; want %d (%v)", step.name, res.StatusCode, step.wantStatus, res.body)
+		}
+	}
+}
+
+func TestActivationTokenRequests(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	// Every request gets the same response; only an inactive account is sent a token.
+	tests := []struct {
+		name      string
+		email     string
+		exists    bool
+		activated bool
+		disabled  bool
+		wantEmail bool
+	}{
+		{name: "no account", email: "activation-nobody@example.com"},
+		{name: "inactive account", email: "activation-inactive@example.com", exists: true, wantEmail: true},
+		{name: "activated account", email: "activation-active@example.com", exists: true, activated: true},
+		{name: "disabled account", email: "activation-disabled@example.com", exists: true, disabled: true},
+	}
+
+	var message string
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.exists {
+				user := insertTestUser(t, application, tt.email, tt.activated)
+
+				if tt.disabled {
+					now := time.Now()
+					user.DisabledAt = &now
+
+					err := application.models.Users.Update(user)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			res := send(t, routes, http.MethodPost, "/tokens/activation", map[string]any{"email": tt.email}, nil)
+			if res.StatusCode != http.StatusAccepted {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, http.StatusAccepted, res.body)
+			}
+
+			if message == "" {
+				message = res.string("message")
+			}
+
+			if got := res.string("message"); got != message {
+				t.Errorf("got message %q; want %q", got, message)
+			}
+
+			application.wg.Wait()
+
+			sent := testMailer.sentTo(tt.email, "token_activation.tmpl")
+			if got := len(sent) == 1; got != tt.wantEmail {
+				t.Errorf("got %d activation emails; want an email: %t", len(sent), tt.wantEmail)
+			}
+		})
+	}
+}
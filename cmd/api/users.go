@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"greenlight.badrchoubai.dev/internal/data"
 	"greenlight.badrchoubai.dev/internal/validator"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,40 +39,60 @@ func (application *application) activateUserHandler(w http.ResponseWriter, r *ht
 			v.AddError("token", "invalid or expired activation token")
 			application.failedValidationResponse(w, r, v.Errors)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if user.IsDisabled() {
+		application.disabledAccountResponse(w, r)
+		return
+	}
+
 	user.Activated = true
 
-	err = application.models.Users.Update(user)
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			application.editConflictResponse(w, r)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
-	}
-
-	err = application.models.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
-	if err != nil {
-		application.serverErrorResponse(w, r, err)
 		return
 	}
 
+	application.invalidateAuthCache(user.ID)
+
+	application.auditUser(r, data.AuditUserActivated, user.ID, map[string]string{"method": "activation_token"})
+
 	err = application.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
 	}
 }
 
+// registerUserHandler creates an account. Accounts are activated by email, unless they
+// are created with an invitation: it was emailed to the address being registered, so
+// the account is activated straight away and given the invitation's permissions.
 func (application *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	if application.config.account.registrationMode == "closed" {
+		application.registrationClosedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name       string `json:"name"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		Invitation string `json:"invitation"`
 	}
 
 	err := application.readJSON(w, r, &input)
@@ -81,55 +107,843 @@ func (application *application) registerUserHandler(w http.ResponseWriter, r *ht
 		Activated: false,
 	}
 
+	v := validator.New()
+	data.ValidateUserDetails(v, user)
+	data.ValidatePasswordPlaintext(v, "password", input.Password)
+
+	var invitation *data.Invitation
+
+	switch {
+	case input.Invitation != "":
+		invitation, err = application.models.Invitations.GetForToken(input.Invitation)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("invitation", "invalid or expired invitation token")
+			default:
+				application.dataErrorResponse(w, r, err)
+				return
+			}
+		} else {
+			v.Check(strings.EqualFold(invitation.Email, input.Email), "email", "must match the address the invitation was sent to")
+		}
+	case application.config.account.registrationMode == "invite":
+		v.AddError("invitation", "must be provided")
+	}
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Hashing is deliberately slow, so it waits until the request is known to be good.
+	release, err := application.passwordHashes.acquire(r.Context())
+	if err != nil {
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	release()
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.Activated = invitation != nil
+
+	var token *data.Token
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		if application.config.account.defaultRole != "" {
+			err = tx.Roles.AddForUser(user.ID, application.config.account.defaultRole)
+			if err != nil {
+				return err
+			}
+		}
+
+		if invitation != nil {
+			err = tx.Invitations.Delete(invitation.ID)
+			if err != nil {
+				return err
+			}
+
+			if len(invitation.Permissions) == 0 {
+				return nil
+			}
+
+			return tx.Permissions.AddForUser(user.ID, invitation.Permissions...)
+		}
+
+		token, err = tx.Token.New(user.ID, 1*24*time.Hour, data.ScopeActivation)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			application.failedValidationResponse(w, r, v.Errors)
+		case invitation != nil && errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation", "invalid or expired invitation token")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if invitation != nil {
+		application.auditUser(r, data.AuditUserActivated, user.ID, map[string]string{"method": "invitation", "invitation": strconv.FormatInt(invitation.ID, 10)})
+
+		if len(invitation.Permissions) > 0 {
+			application.auditUser(r, data.AuditPermissionsGranted, user.ID, map[string]string{"permissions": strings.Join(invitation.Permissions, ",")})
+		}
+
+		err = application.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+		if err != nil {
+			application.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.background(func() {
+		userActivationInfo := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+
+		err = application.mailer.Send(user.Email, "user_welcome.tmpl", userActivationInfo)
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	err = application.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, "password", input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := application.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = user.Password.Set(input.Password)
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// Changing the password also signs the user out everywhere, so a stolen session
+	// can't outlive the reset.
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+			err = tx.Token.DeleteAllForUser(scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	err = application.revokeUserSignedTokens(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditPasswordChanged, user.ID, map[string]string{"method": "reset_token"})
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+// accountResponse is the representation of a user's own account. Unlike the public User
+// it includes the record version, which clients send back when updating the account.
+type accountResponse struct {
+	*data.User
+	Version     int              `json:"version"`
+	Roles       []string         `json:"roles"`
+	Permissions data.Permissions `json:"permissions"`
+}
+
+func (application *application) newAccountResponse(user *data.User) (*accountResponse, error) {
+	roles, err := application.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := application.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	return &accountResponse{User: user, Version: user.Version, Roles: roles, Permissions: permissions}, nil
+}
+
+func (application *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	account, err := application.newAccountResponse(user)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"user": account}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		Name    *string `json:"name"`
+		Version *int    `json:"version"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
 	v := validator.New()
+
+	if v.Check(input.Version != nil, "version", "must be provided"); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Refuse to overwrite anything newer than the version the client last saw.
+	if *input.Version != user.Version {
+		application.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
 	if data.ValidateUser(v, user); !v.Valid() {
 		application.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = application.models.Users.Insert(user)
+	err = application.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	account, err := application.newAccountResponse(user)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"user": account}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) updateCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, "new_password", input.NewPassword)
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = application.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	application.auditUser(r, data.AuditPasswordChanged, user.ID, map[string]string{"method": "change"})
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from the current email address")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// This check only gives early feedback. The address can still be taken before the
+	// change is confirmed, which is caught by the unique constraint at that point.
+	_, err = application.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email already exists")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	var token *data.Token
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Token.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Token.NewWithPayload(user.ID, 24*time.Hour, data.ScopeEmailChange, input.Email)
+		return err
+	})
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditEmailChangeRequested, user.ID, map[string]string{"email": input.Email})
+
+	application.background(func() {
+		err := application.mailer.Send(input.Email, "email_change_confirm.tmpl", map[string]any{
+			"emailChangeToken": token.Plaintext,
+		})
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+
+		err = application.mailer.Send(user.Email, "email_change_notice.tmpl", map[string]any{
+			"newEmail": input.Email,
+		})
+		if err != nil {
+			application.log.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing instructions to confirm the change"}
+
+	err = application.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := application.models.Token.Get(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			application.failedValidationResponse(w, r, v.Errors)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := application.models.Users.Get(token.UserID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	previousEmail := user.Email
+	user.Email = token.Payload
+
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Token.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email already exists")
 			application.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
 		default:
-			application.serverErrorResponse(w, r, err)
+			application.dataErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = application.models.Permissions.AddForUser(user.ID, "movies:read")
+	application.invalidateAuthCache(user.ID)
+
+	application.auditUser(r, data.AuditEmailChanged, user.ID, map[string]string{"previous_email": previousEmail, "email": user.Email})
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := application.readJSON(w, r, &input)
+	if err != nil {
+		application.badRequestResponse(w, r, err)
 		return
 	}
 
-	token, err := application.models.Token.New(user.ID, 1*24*time.Hour, data.ScopeActivation)
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
 		return
 	}
 
-	application.background(func() {
-		userActivationInfo := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
+	if !match {
+		v.AddError("password", "is incorrect")
+		application.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scheduledDeletionAt := time.Now().Add(application.config.account.deletionGracePeriod).Truncate(time.Second)
+	user.ScheduledDeletionAt = &scheduledDeletionAt
+
+	// Revoking every token and API key signs the user out everywhere. Signing in again
+	// during the grace period cancels the deletion.
+	err = application.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
 		}
 
-		err = application.mailer.Send(user.Email, "user_welcome.tmpl", userActivationInfo)
+		err = tx.Token.DeleteAllScopesForUser(user.ID)
 		if err != nil {
-			application.log.PrintError(err, nil)
+			return err
 		}
+
+		return tx.APIKeys.DeleteAllForUser(user.ID)
 	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			application.editConflictResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
 
-	err = application.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	application.invalidateAuthCache(user.ID)
+
+	err = application.revokeUserSignedTokens(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditDeletionScheduled, user.ID, map[string]string{"scheduled_deletion_at": scheduledDeletionAt.Format(time.RFC3339)})
+
+	application.clearSessionCookies(w, r)
+
+	env := envelope{
+		"message":               "your account is scheduled for deletion, sign in again before then to cancel",
+		"scheduled_deletion_at": scheduledDeletionAt,
+	}
+
+	err = application.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	account, err := application.newAccountResponse(user)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	tokens, err := application.models.Token.GetAllForUser(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// Only token metadata is exported. The plaintext is never stored and the hash is of
+	// no use to the user.
+	type tokenExport struct {
+		Scope      string     `json:"scope"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+		Expiry     time.Time  `json:"expiry"`
+		UserAgent  string     `json:"user_agent,omitempty"`
+		IP         string     `json:"ip,omitempty"`
+		Payload    string     `json:"payload,omitempty"`
+	}
+
+	tokenExports := make([]tokenExport, len(tokens))
+	for i, token := range tokens {
+		tokenExports[i] = tokenExport{
+			Scope:      token.Scope,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			Expiry:     token.Expiry,
+			UserAgent:  token.UserAgent,
+			IP:         token.IP,
+			Payload:    token.Payload,
+		}
+	}
+
+	apiKeys, err := application.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// The secret and the recovery codes are left out, like token hashes.
+	type mfaExport struct {
+		Enabled                bool       `json:"enabled"`
+		CreatedAt              time.Time  `json:"created_at"`
+		ConfirmedAt            *time.Time `json:"confirmed_at"`
+		RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	}
+
+	var mfaEnrolment *mfaExport
+
+	mfa, err := application.models.MFA.Get(user.ID)
+	switch {
+	case err == nil:
+		remaining, err := application.models.MFA.CountRecoveryCodes(user.ID)
+		if err != nil {
+			application.dataErrorResponse(w, r, err)
+			return
+		}
+
+		mfaEnrolment = &mfaExport{
+			Enabled:                mfa.Enabled(),
+			CreatedAt:              mfa.CreatedAt,
+			ConfirmedAt:            mfa.ConfirmedAt,
+			RecoveryCodesRemaining: remaining,
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	loginAttempts, err := application.models.LoginAttempts.GetAllForEmail(user.Email)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	auditEvents, err := application.models.AuditEvents.GetAllForUser(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// Where someone else acted on the user, such as an admin, their IP address and
+	// user agent are theirs rather than the user's, so they are left out.
+	for _, event := range auditEvents {
+		if event.ActorID == nil || *event.ActorID != user.ID {
+			event.IP = ""
+			event.UserAgent = ""
+		}
+	}
+
+	movies, err := application.models.Movies.GetAllCreatedBy(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	invitations, err := application.models.Invitations.GetAllCreatedBy(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"export": envelope{
+			"generated_at":   time.Now().UTC(),
+			"user":           account,
+			"tokens":         tokenExports,
+			"api_keys":       apiKeys,
+			"mfa":            mfaEnrolment,
+			"login_attempts": loginAttempts,
+			"audit_events":   auditEvents,
+			"movies":         movies,
+			"invitations":    invitations,
+		},
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenlight-user-%d.json"`, user.ID))
+
+	err = application.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) listCurrentUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	tokens, err := application.models.Token.GetAllForUser(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	type session struct {
+		ID         string     `json:"id"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		Expiry     time.Time  `json:"expiry"`
+		UserAgent  string     `json:"user_agent"`
+		IP         string     `json:"ip"`
+		Current    bool       `json:"current"`
+	}
+
+	currentHash := sha256.Sum256([]byte(application.contextGetToken(r)))
+
+	// Signed access tokens aren't stored, so in signed mode a session is represented
+	// by its unused refresh token instead.
+	claims := application.contextGetClaims(r)
+
+	sessions := []session{}
+	for _, token := range tokens {
+		var current bool
+
+		switch {
+		case claims != nil && token.Scope == data.ScopeRefresh && token.UsedAt == nil:
+			current = token.Family == claims.Family
+		case claims == nil && token.Scope == data.ScopeAuthentication:
+			current = bytes.Equal(token.Hash, currentHash[:])
+		default:
+			continue
+		}
+
+		sessions = append(sessions, session{
+			ID:         token.ID,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			Expiry:     token.Expiry,
+			UserAgent:  token.UserAgent,
+			IP:         token.IP,
+			Current:    current,
+		})
+	}
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		application.serverErrorResponse(w, r, err)
+	}
+}
+
+func (application *application) deleteCurrentUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := application.contextGetUser(r)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	err := application.models.Token.DeleteByID(user.ID, params.ByName("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			application.notFoundResponse(w, r)
+		default:
+			application.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	application.invalidateAuthCache(user.ID)
+
+	// The session's signed access token isn't stored, so it can't be picked out. All of
+	// the user's signed access tokens are revoked instead; the other sessions get new
+	// ones at their next refresh.
+	err = application.revokeUserSignedTokens(user.ID)
+	if err != nil {
+		application.dataErrorResponse(w, r, err)
+		return
+	}
+
+	application.auditUser(r, data.AuditTokenRevoked, user.ID, map[string]string{"reason": "session_revoked", "session": params.ByName("id")})
+
+	err = application.writeJSON(w, http.StatusOK, envelope{"message": "session revoked successfully"}, nil)
 	if err != nil {
 		application.serverErrorResponse(w, r, err)
 	}
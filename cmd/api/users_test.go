@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUpdateCurrentUserValidation(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	user := insertTestUser(t, application, "update-me@example.com", true)
+	token := bearer(signIn(t, routes, user.Email, false).string("authentication_token", "token"))
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+		wantError  string
+	}{
+		{name: "missing version", body: map[string]any{"name": "Changed"}, wantStatus: http.StatusUnprocessableEntity, wantError: "version"},
+		{name: "stale version", body: map[string]any{"name": "Changed", "version": user.Version + 1}, wantStatus: http.StatusConflict},
+		{name: "current version", body: map[string]any{"name": "Changed", "version": user.Version}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, routes, http.MethodPatch, "/users/me", tt.body, token)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			if tt.wantError != "" && res.string("error", tt.wantError) == "" {
+				t.Errorf("got %v; want an error for %q", res.body, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestUpdateCurrentUserPasswordValidation(t *testing.T) {
+	application, routes := newTestApplication(t)
+
+	user := insertTestUser(t, application, "change-password@example.com", true)
+	token := bearer(signIn(t, routes, user.Email, false).string("authentication_token", "token"))
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+		wantError  string
+	}{
+		{name: "missing current password", body: map[string]any{"new_password": "n3wpa55word"}, wantStatus: http.StatusUnprocessableEntity, wantError: "current_password"},
+		{name: "short new password", body: map[string]any{"current_password": testPassword, "new_password": "short"}, wantStatus: http.StatusUnprocessableEntity, wantError: "new_password"},
+		{name: "wrong current password", body: map[string]any{"current_password": "wr0ngpa55word", "new_password": "n3wpa55word"}, wantStatus: http.StatusUnprocessableEntity, wantError: "current_password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, routes, http.MethodPut, "/users/me/password", tt.body, token)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, res.body)
+			}
+
+			if res.string("error", tt.wantError) == "" {
+				t.Errorf("got %v; want an error for %q", res.body, tt.wantError)
+			}
+		})
+	}
+}
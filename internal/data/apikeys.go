@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, which makes keys easy to tell apart from tokens
+// and easy to spot when one leaks into a log or a repository.
+const APIKeyPrefix = "glk_"
+
+type (
+	// APIKey is a long-lived credential for machine clients. It acts on behalf of the
+	// user that created it, but only with the permissions it was created with.
+	APIKey struct {
+		ID          string      `json:"id"`
+		Plaintext   string      `json:"key,omitempty"`
+		Hash        []byte      `json:"-"`
+		UserID      int64       `json:"-"`
+		Name        string      `json:"name"`
+		Permissions Permissions `json:"permissions"`
+		// AllowedIPs holds addresses and CIDR prefixes the key may be used from. An
+		// empty list allows any address.
+		AllowedIPs []string   `json:"allowed_ips"`
+		Expiry     *time.Time `json:"expiry"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+	}
+
+	IAPIKeyModel interface {
+		Insert(key *APIKey) error
+		Get(plaintext string) (*APIKey, error)
+		GetAllForUser(userID int64) ([]*APIKey, error)
+		Touch(id string) error
+		Delete(userID int64, id string) error
+		DeleteAllForUser(userID int64) error
+	}
+)
+
+// GenerateAPIKey creates an API key without storing it, so callers can fill in the name,
+// permissions and restrictions before passing it to Insert.
+func GenerateAPIKey(userID int64) (*APIKey, error) {
+	key := &APIKey{
+		UserID:     userID,
+		AllowedIPs: []string{},
+		CreatedAt:  time.Now().Truncate(time.Second),
+	}
+
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(key.Plaintext))
+
+	key.Hash = hash[:]
+
+	key.ID, err = randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// AllowsIP reports whether the key may be used from the given address.
+func (key *APIKey) AllowsIP(ip string) bool {
+	if len(key.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range key.AllowedIPs {
+		prefix, err := parseIPOrPrefix(allowed)
+		if err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseIPOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", "must be provided")
+	v.Check(strings.HasPrefix(plaintext, APIKeyPrefix), "key", "must be an API key")
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be 36 bytes long")
+}
+
+// ValidateAPIKey checks a new key against the permissions of the user creating it. A key
+// can never be given a permission its user doesn't have.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, userPermissions Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 characters long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(validator.Matches(code, permissionCodeRX), "permissions", "must only contain codes of the form resource:action, where either part may be *")
+		v.Check(userPermissions.Include(code), "permissions", "must be a subset of your own permissions")
+	}
+
+	v.Check(len(key.AllowedIPs) <= 20, "allowed_ips", "must not contain more than 20 entries")
+	for _, allowed := range key.AllowedIPs {
+		_, err := parseIPOrPrefix(allowed)
+		v.Check(err == nil, "allowed_ips", "must contain only IP addresses or CIDR prefixes")
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+func (model APIKeyModel) Insert(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, hash, user_id, name, permissions, allowed_ips, expiry, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []any{
+		key.ID,
+		key.Hash,
+		key.UserID,
+		key.Name,
+		pq.Array(key.Permissions),
+		pq.Array(key.AllowedIPs),
+		key.Expiry,
+		key.CreatedAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, args...)
+	return mapError(err)
+}
+
+// Get returns the unexpired API key with the given plaintext. The returned key doesn't
+// include the plaintext.
+func (model APIKeyModel) Get(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		SELECT id, hash, user_id, name, permissions, allowed_ips, expiry, created_at, last_used_at
+		FROM api_keys
+		WHERE hash = $1
+		AND (expiry IS NULL OR expiry > $2)`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&key.ID,
+		&key.Hash,
+		&key.UserID,
+		&key.Name,
+		pq.Array(&key.Permissions),
+		pq.Array(&key.AllowedIPs),
+		&key.Expiry,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, mapError(err)
+		}
+	}
+
+	return &key, nil
+}
+
+// GetAllForUser returns every API key of the user, expired ones included, newest first.
+func (model APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, hash, user_id, name, permissions, allowed_ips, expiry, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.Hash,
+			&key.UserID,
+			&key.Name,
+			pq.Array(&key.Permissions),
+			pq.Array(&key.AllowedIPs),
+			&key.Expiry,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return keys, nil
+}
+
+// Touch records that the key was just used, at most once a minute like Token.Touch.
+func (model APIKeyModel) Touch(id string) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, id)
+	return mapError(err)
+}
+
+// Delete revokes one of the user's API keys. It returns ErrRecordNotFound if the user
+// has no key with that ID.
+func (model APIKeyModel) Delete(userID int64, id string) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (model APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, userID)
+	return mapError(err)
+}
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditLoginSucceeded           = "login.succeeded"
+	AuditLoginFailed              = "login.failed"
+	AuditTokenCreated             = "token.created"
+	AuditTokenRevoked             = "token.revoked"
+	AuditAPIKeyCreated            = "apikey.created"
+	AuditAPIKeyRevoked            = "apikey.revoked"
+	AuditUserActivated            = "user.activated"
+	AuditUserDisabled             = "user.disabled"
+	AuditUserEnabled              = "user.enabled"
+	AuditDeletionScheduled        = "user.deletion_scheduled"
+	AuditDeletionCanceled         = "user.deletion_canceled"
+	AuditPasswordChanged          = "password.changed"
+	AuditEmailChangeRequested     = "email.change_requested"
+	AuditEmailChanged             = "email.changed"
+	AuditMFAEnrolled              = "mfa.enrolled"
+	AuditMFAEnabled               = "mfa.enabled"
+	AuditMFADisabled              = "mfa.disabled"
+	AuditRecoveryCodesRegenerated = "mfa.recovery_codes_regenerated"
+	AuditPermissionsGranted       = "permissions.granted"
+	AuditPermissionsRevoked       = "permissions.revoked"
+	AuditRolesAssigned            = "roles.assigned"
+	AuditRolesRemoved             = "roles.removed"
+	AuditRoleCreated              = "role.created"
+	AuditInvitationCreated        = "invitation.created"
+	AuditAccessDenied             = "access.denied"
+)
+
+type (
+	// AuditEvent records a security relevant event for later review. ActorID is the
+	// user who acted, when known, and SubjectID the user the event is about.
+	AuditEvent struct {
+		ID        int64             `json:"id"`
+		CreatedAt time.Time         `json:"created_at"`
+		Action    string            `json:"action"`
+		ActorID   *int64            `json:"actor_id"`
+		SubjectID *int64            `json:"subject_id"`
+		IP        string            `json:"ip"`
+		UserAgent string            `json:"user_agent"`
+		RequestID string            `json:"request_id"`
+		Details   map[string]string `json:"details"`
+	}
+
+	// AuditEventFilter narrows down the events returned by GetAll. Zero values don't
+	// filter.
+	AuditEventFilter struct {
+		Action    string
+		ActorID   *int64
+		SubjectID *int64
+		IP        string
+		RequestID string
+		Since     *time.Time
+		Until     *time.Time
+	}
+
+	IAuditEventModel interface {
+		Insert(event *AuditEvent) error
+		GetAll(filter AuditEventFilter, filters FilterOptions) ([]*AuditEvent, Metadata, error)
+		GetAllForUser(userID int64) ([]*AuditEvent, error)
+	}
+)
+
+func (m AuditEventModel) Insert(event *AuditEvent) error {
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_events (action, actor_id, subject_id, ip, user_agent, request_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	args := []any{event.Action, event.ActorID, event.SubjectID, event.IP, event.UserAgent, event.RequestID, details}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+	return mapError(err)
+}
+
+// GetAll returns the events matching filter. Since is inclusive and Until exclusive.
+func (m AuditEventModel) GetAll(filter AuditEventFilter, filters FilterOptions) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, action, actor_id, subject_id, ip, user_agent, request_id, details
+		FROM audit_events
+		WHERE (action = $1 OR $1 = '')
+		AND (actor_id = $2 OR $2 IS NULL)
+		AND (subject_id = $3 OR $3 IS NULL)
+		AND (ip = $4 OR $4 = '')
+		AND (request_id = $5 OR $5 = '')
+		AND (created_at >= $6 OR $6 IS NULL)
+		AND (created_at < $7 OR $7 IS NULL)
+		ORDER BY %s %s, id DESC
+		LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{
+		filter.Action,
+		filter.ActorID,
+		filter.SubjectID,
+		filter.IP,
+		filter.RequestID,
+		filter.Since,
+		filter.Until,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, mapError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var details []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.ActorID,
+			&event.SubjectID,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&details,
+		)
+		if err != nil {
+			return nil, Metadata{}, mapError(err)
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, mapError(err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// GetAllForUser returns every event the user is the actor or the subject of, oldest
+// first.
+func (m AuditEventModel) GetAllForUser(userID int64) ([]*AuditEvent, error) {
+	query := `
+		SELECT id, created_at, action, actor_id, subject_id, ip, user_agent, request_id, details
+		FROM audit_events
+		WHERE actor_id = $1 OR subject_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var details []byte
+
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.ActorID,
+			&event.SubjectID,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&details,
+		)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return events, nil
+}
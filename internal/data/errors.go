@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUniqueViolation      = errors.New("unique constraint violation")
+	ErrCheckViolation       = errors.New("check constraint violation")
+	ErrForeignKeyViolation  = errors.New("foreign key violation")
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrQueryTimeout         = errors.New("query timeout")
+)
+
+// constraintErrors maps constraint names to the more specific errors that callers
+// check for. A violation of one of these matches both the general kind and the
+// specific error with errors.Is.
+var constraintErrors = map[string]error{
+	"roles_name_key":  ErrDuplicateRoleName,
+	"users_email_key": ErrDuplicateEmail,
+}
+
+// DBError is a classified database error. Kind is one of the sentinel errors above and
+// Err is the original driver error.
+type DBError struct {
+	Kind       error
+	Constraint string
+	Err        error
+}
+
+func (e *DBError) Error() string {
+	if e.Constraint != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Kind, e.Constraint, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+func (e *DBError) Unwrap() []error {
+	errs := []error{e.Kind, e.Err}
+
+	if specific, ok := constraintErrors[e.Constraint]; ok {
+		errs = append(errs, specific)
+	}
+
+	return errs
+}
+
+// mapError classifies errors returned by the driver using their SQLSTATE code. Errors
+// that don't need special handling, including sql.ErrNoRows, are returned unchanged.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &DBError{Kind: ErrQueryTimeout, Err: err}
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	var kind error
+
+	switch pqErr.Code {
+	case "23505": // unique_violation
+		kind = ErrUniqueViolation
+	case "23514": // check_violation
+		kind = ErrCheckViolation
+	case "23503": // foreign_key_violation
+		kind = ErrForeignKeyViolation
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		kind = ErrSerializationFailure
+	case "57014", "55P03": // query_canceled, lock_not_available
+		kind = ErrQueryTimeout
+	default:
+		return err
+	}
+
+	return &DBError{Kind: kind, Constraint: pqErr.Constraint, Err: err}
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"testing"
+)
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// want are the errors the result must match with errors.Is, and notWant the
+		// ones it must not.
+		want    []error
+		notWant []error
+		// unchanged means the error is returned as it was passed in.
+		unchanged bool
+	}{
+		{
+			name: "nil",
+			err:  nil,
+		},
+		{
+			name:      "no rows",
+			err:       sql.ErrNoRows,
+			want:      []error{sql.ErrNoRows},
+			unchanged: true,
+		},
+		{
+			name:      "other error",
+			err:       errors.New("boom"),
+			notWant:   []error{ErrUniqueViolation, ErrQueryTimeout},
+			unchanged: true,
+		},
+		{
+			name: "deadline exceeded",
+			err:  fmt.Errorf("querying: %w", context.DeadlineExceeded),
+			want: []error{ErrQueryTimeout, context.DeadlineExceeded},
+		},
+		{
+			name:    "unique violation",
+			err:     &pq.Error{Code: "23505", Constraint: "movies_pkey"},
+			want:    []error{ErrUniqueViolation},
+			notWant: []error{ErrDuplicateEmail, ErrDuplicateRoleName},
+		},
+		{
+			name: "duplicate email",
+			err:  &pq.Error{Code: "23505", Constraint: "users_email_key"},
+			want: []error{ErrUniqueViolation, ErrDuplicateEmail},
+		},
+		{
+			name: "duplicate role name",
+			err:  &pq.Error{Code: "23505", Constraint: "roles_name_key"},
+			want: []error{ErrUniqueViolation, ErrDuplicateRoleName},
+		},
+		{
+			name:    "specific error needs a unique violation",
+			err:     &pq.Error{Code: "23514", Constraint: "users_email_key"},
+			want:    []error{ErrCheckViolation},
+			notWant: []error{ErrUniqueViolation},
+		},
+		{
+			name: "foreign key violation",
+			err:  &pq.Error{Code: "23503"},
+			want: []error{ErrForeignKeyViolation},
+		},
+		{
+			name: "serialization failure",
+			err:  &pq.Error{Code: "40001"},
+			want: []error{ErrSerializationFailure},
+		},
+		{
+			name: "deadlock",
+			err:  &pq.Error{Code: "40P01"},
+			want: []error{ErrSerializationFailure},
+		},
+		{
+			name: "query canceled",
+			err:  &pq.Error{Code: "57014"},
+			want: []error{ErrQueryTimeout},
+		},
+		{
+			name: "lock not available",
+			err:  &pq.Error{Code: "55P03"},
+			want: []error{ErrQueryTimeout},
+		},
+		{
+			name:      "unclassified SQLSTATE",
+			err:       &pq.Error{Code: "42601"},
+			notWant:   []error{ErrUniqueViolation, ErrCheckViolation, ErrQueryTimeout},
+			unchanged: true,
+		},
+		{
+			name: "wrapped driver error",
+			err:  fmt.Errorf("inserting: %w", &pq.Error{Code: "23505", Constraint: "users_email_key"}),
+			want: []error{ErrUniqueViolation, ErrDuplicateEmail},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mapError(tt.err)
+
+			if tt.err == nil {
+				if got != nil {
+					t.Fatalf("got %v; want nil", got)
+				}
+				return
+			}
+
+			if tt.unchanged && got != tt.err {
+				t.Errorf("got %v; want the error unchanged", got)
+			}
+
+			for _, want := range tt.want {
+				if !errors.Is(got, want) {
+					t.Errorf("errors.Is(%v, %v) = false; want true", got, want)
+				}
+			}
+
+			for _, notWant := range tt.notWant {
+				if errors.Is(got, notWant) {
+					t.Errorf("errors.Is(%v, %v) = true; want false", got, notWant)
+				}
+			}
+
+			if !errors.Is(got, tt.err) {
+				t.Errorf("the result doesn't wrap the original error %v", tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+type (
+	// Invitation lets someone who doesn't have an account yet register with the email
+	// address it was sent to, even when registration is otherwise restricted. The
+	// permissions are granted to the account it creates.
+	Invitation struct {
+		ID          int64       `json:"id"`
+		Plaintext   string      `json:"-"`
+		Hash        []byte      `json:"-"`
+		Email       string      `json:"email"`
+		Permissions Permissions `json:"permissions"`
+		CreatedBy   *int64      `json:"created_by,omitempty"`
+		CreatedAt   time.Time   `json:"created_at"`
+		Expiry      time.Time   `json:"expiry"`
+	}
+
+	IInvitationModel interface {
+		Insert(invitation *Invitation) error
+		GetForToken(tokenPlaintext string) (*Invitation, error)
+		GetAllCreatedBy(userID int64) ([]*Invitation, error)
+		Delete(id int64) error
+		DeleteAllForEmail(email string) error
+	}
+)
+
+// GenerateInvitation creates an invitation without storing it.
+func GenerateInvitation(email string, permissions Permissions, createdBy *int64, ttl time.Duration) (*Invitation, error) {
+	invitation := &Invitation{
+		Email:       email,
+		Permissions: permissions,
+		CreatedBy:   createdBy,
+		Expiry:      time.Now().Add(ttl),
+	}
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = Permissions{}
+	}
+
+	var err error
+
+	invitation.Plaintext, invitation.Hash, err = generateTokenPlaintext()
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+func (m InvitationModel) Insert(invitation *Invitation) error {
+	query := `
+		INSERT INTO invitations (hash, email, permissions, created_by, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{invitation.Hash, invitation.Email, pq.Array(invitation.Permissions), invitation.CreatedBy, invitation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	return mapError(err)
+}
+
+// GetForToken returns the unexpired invitation with the given token plaintext. The
+// returned invitation doesn't include the plaintext.
+func (m InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT id, hash, email, permissions, created_by, created_at, expiry
+		FROM invitations
+		WHERE hash = $1
+		AND expiry > $2`
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.Hash,
+		&invitation.Email,
+		pq.Array(&invitation.Permissions),
+		&invitation.CreatedBy,
+		&invitation.CreatedAt,
+		&invitation.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, mapError(err)
+		}
+	}
+
+	return &invitation, nil
+}
+
+// GetAllCreatedBy returns the invitations the user sent that haven't been used or
+// replaced yet, expired ones included, oldest first.
+func (m InvitationModel) GetAllCreatedBy(userID int64) ([]*Invitation, error) {
+	query := `
+		SELECT id, hash, email, permissions, created_by, created_at, expiry
+		FROM invitations
+		WHERE created_by = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.Hash,
+			&invitation.Email,
+			pq.Array(&invitation.Permissions),
+			&invitation.CreatedBy,
+			&invitation.CreatedAt,
+			&invitation.Expiry,
+		)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return invitations, nil
+}
+
+// Delete removes the invitation, returning ErrRecordNotFound if it is already gone, so
+// an invitation used twice at the same time is only accepted once.
+func (m InvitationModel) Delete(id int64) error {
+	query := `
+		DELETE FROM invitations
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m InvitationModel) DeleteAllForEmail(email string) error {
+	query := `
+		DELETE FROM invitations
+		WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return mapError(err)
+}
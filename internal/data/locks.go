@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+)
+
+type (
+	// ILockModel takes Postgres advisory locks, which let instances sharing a database
+	// agree on which of them does a piece of work.
+	ILockModel interface {
+		TryLock(name string) (unlock func() error, acquired bool, err error)
+	}
+)
+
+// TryLock takes the session-level advisory lock called name, or returns false straight
+// away if another session holds it. The lock lives on a connection set aside for it, so
+// it is held across any number of transactions until unlock is called. That connection
+// can't be borrowed from a transaction, so Models bound to one return ErrTxInProgress.
+func (m LockModel) TryLock(name string) (func() error, bool, error) {
+	db, ok := m.DB.(*sql.DB)
+	if !ok {
+		return nil, false, ErrTxInProgress
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, mapError(err)
+	}
+
+	var acquired bool
+
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, name).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		return nil, false, mapError(err)
+	}
+
+	unlock := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, name)
+		if err != nil {
+			// The lock may still be held, so the connection must not go back to the
+			// pool. Closing it ends the session, which releases the lock.
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			conn.Close()
+			return mapError(err)
+		}
+
+		return conn.Close()
+	}
+
+	return unlock, true, nil
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// LoginFailures summarises the failed sign in attempts for an email address or an
+	// IP address since some point in time.
+	LoginFailures struct {
+		Count int
+		Last  time.Time
+	}
+
+	// LoginAttempt is a single failed sign in attempt.
+	LoginAttempt struct {
+		Email     string    `json:"email"`
+		IP        string    `json:"ip"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	ILoginAttemptModel interface {
+		InsertFailure(email, ip string) error
+		GetAllForEmail(email string) ([]*LoginAttempt, error)
+		FailuresForEmail(email string, since time.Time) (LoginFailures, error)
+		FailuresForIP(ip string, since time.Time) (LoginFailures, error)
+		DeleteForEmail(email string) error
+		DeleteBefore(before time.Time) (int64, error)
+	}
+)
+
+// InsertFailure records a failed sign in attempt. The email address is stored whether or
+// not an account exists for it, so guessing against unknown addresses is throttled the
+// same way.
+func (model LoginAttemptModel) InsertFailure(email, ip string) error {
+	query := `
+		INSERT INTO login_attempts (email, ip)
+		VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, email, ip)
+	return mapError(err)
+}
+
+// GetAllForEmail returns the failed sign in attempts for an email address that haven't
+// been forgotten yet, oldest first.
+func (model LoginAttemptModel) GetAllForEmail(email string) ([]*LoginAttempt, error) {
+	query := `
+		SELECT email, ip, created_at
+		FROM login_attempts
+		WHERE email = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	attempts := []*LoginAttempt{}
+
+	for rows.Next() {
+		var attempt LoginAttempt
+
+		err := rows.Scan(&attempt.Email, &attempt.IP, &attempt.CreatedAt)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		attempts = append(attempts, &attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return attempts, nil
+}
+
+func (model LoginAttemptModel) FailuresForEmail(email string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT count(*), COALESCE(max(created_at), 'epoch')
+		FROM login_attempts
+		WHERE email = $1 AND created_at > $2`
+
+	return model.failures(query, email, since)
+}
+
+func (model LoginAttemptModel) FailuresForIP(ip string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT count(*), COALESCE(max(created_at), 'epoch')
+		FROM login_attempts
+		WHERE ip = $1 AND created_at > $2`
+
+	return model.failures(query, ip, since)
+}
+
+func (model LoginAttemptModel) failures(query string, args ...any) (LoginFailures, error) {
+	var failures LoginFailures
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&failures.Count, &failures.Last)
+	return failures, mapError(err)
+}
+
+// DeleteForEmail forgets the failed attempts for an email address, which happens once
+// someone signs in to it successfully.
+func (model LoginAttemptModel) DeleteForEmail(email string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, email)
+	return mapError(err)
+}
+
+func (model LoginAttemptModel) DeleteBefore(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,1684 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore holds every table for the in-memory models. A single mutex guards all of
+// it, which keeps the implementation simple and is plenty for tests and local demos.
+//
+// A transaction holds mu from Begin until it finishes and works on a private copy of
+// the tables, which Commit swaps in. Other callers wait for it rather than seeing its
+// uncommitted writes, and Rollback only has to drop the copy. Code running inside a
+// transaction must therefore only use the transaction's models.
+//
+// locks holds the names of the advisory locks that are taken. It isn't a table, so
+// transactions don't copy it, and only the top-level store has one.
+type memoryStore struct {
+	mu    sync.Mutex
+	locks map[string]bool
+	memoryTables
+}
+
+type memoryTables struct {
+	movies      map[int64]*Movie
+	lastMovieID int64
+
+	users      map[int64]*User
+	lastUserID int64
+
+	tokens map[[sha256.Size]byte]*Token
+
+	permissions     []*Permission
+	userPermissions map[int64]map[string]bool
+
+	roles      []*Role
+	lastRoleID int64
+	userRoles  map[int64]map[string]bool
+
+	revokedTokens     map[string]time.Time
+	revokedUserTokens map[int64]RevokedUserTokens
+
+	apiKeys map[string]*APIKey
+
+	mfa           map[int64]*MFA
+	recoveryCodes map[int64]map[[sha256.Size]byte]bool
+
+	invitations      map[[sha256.Size]byte]*Invitation
+	lastInvitationID int64
+
+	loginAttempts []memoryLoginAttempt
+
+	auditEvents      []*AuditEvent
+	lastAuditEventID int64
+}
+
+type memoryLoginAttempt struct {
+	email     string
+	ip        string
+	createdAt time.Time
+}
+
+type memoryAPIKeyModel struct{ store *memoryStore }
+type memoryAuditEventModel struct{ store *memoryStore }
+type memoryInvitationModel struct{ store *memoryStore }
+type memoryLockModel struct{ store *memoryStore }
+type memoryLoginAttemptModel struct{ store *memoryStore }
+type memoryMFAModel struct{ store *memoryStore }
+type memoryMovieModel struct{ store *memoryStore }
+type memoryNotificationModel struct{ store *memoryStore }
+type memoryPermissionModel struct{ store *memoryStore }
+type memoryRevokedTokenModel struct{ store *memoryStore }
+type memoryRoleModel struct{ store *memoryStore }
+type memoryTokenModel struct{ store *memoryStore }
+type memoryUserModel struct{ store *memoryStore }
+
+// NewMemoryModels returns a Models backed by process memory instead of PostgreSQL. It
+// mirrors the behaviour of the SQL models (filtering, sorting, pagination, edit
+// conflicts and token expiry) so the API can run without a database.
+func NewMemoryModels() Models {
+	store := &memoryStore{
+		locks: make(map[string]bool),
+		memoryTables: memoryTables{
+			movies: make(map[int64]*Movie),
+			users:  make(map[int64]*User),
+			tokens: make(map[[sha256.Size]byte]*Token),
+			permissions: []*Permission{
+				{Code: "movies:read", Description: "Read movies"},
+				{Code: "movies:write", Description: "Create, update and delete movies"},
+				{Code: "apikeys:manage", Description: "Create and revoke API keys"},
+				{Code: "users:admin", Description: "Manage user accounts, roles and permissions"},
+				{Code: "movies:admin", Description: "Update and delete movies created by anyone"},
+				{Code: "audit:read", Description: "Read the security audit log"},
+				{Code: "*:*"},
+			},
+			userPermissions:   make(map[int64]map[string]bool),
+			userRoles:         make(map[int64]map[string]bool),
+			revokedTokens:     make(map[string]time.Time),
+			revokedUserTokens: make(map[int64]RevokedUserTokens),
+			apiKeys:           make(map[string]*APIKey),
+			invitations:       make(map[[sha256.Size]byte]*Invitation),
+			mfa:               make(map[int64]*MFA),
+			recoveryCodes:     make(map[int64]map[[sha256.Size]byte]bool),
+		},
+	}
+
+	// Seed the same roles as the migrations.
+	for _, role := range []*Role{
+		{Name: "viewer", Permissions: Permissions{"movies:read"}},
+		{Name: "editor", Permissions: Permissions{"movies:read", "movies:write"}},
+		{Name: "admin", Permissions: Permissions{"*:*"}},
+	} {
+		store.lastRoleID++
+		role.ID = store.lastRoleID
+		role.CreatedAt = time.Now().Truncate(time.Second)
+		store.roles = append(store.roles, role)
+	}
+
+	models := newMemoryModels(store)
+	models.begin = store.begin
+
+	return models
+}
+
+func newMemoryModels(store *memoryStore) Models {
+	return Models{
+		APIKeys:       memoryAPIKeyModel{store: store},
+		AuditEvents:   memoryAuditEventModel{store: store},
+		Invitations:   memoryInvitationModel{store: store},
+		Locks:         memoryLockModel{store: store},
+		LoginAttempts: memoryLoginAttemptModel{store: store},
+		MFA:           memoryMFAModel{store: store},
+		Movies:        memoryMovieModel{store: store},
+		Notifications: memoryNotificationModel{store: store},
+		Permissions:   memoryPermissionModel{store: store},
+		RevokedTokens: memoryRevokedTokenModel{store: store},
+		Roles:         memoryRoleModel{store: store},
+		Token:         memoryTokenModel{store: store},
+		Users:         memoryUserModel{store: store},
+	}
+}
+
+func (store *memoryStore) begin() (*Tx, error) {
+	store.mu.Lock()
+
+	tx := &memoryStore{memoryTables: store.memoryTables.clone()}
+
+	return &Tx{
+		Models: newMemoryModels(tx),
+		commit: func() error {
+			store.memoryTables = tx.memoryTables
+			store.mu.Unlock()
+			return nil
+		},
+		rollback: func() error {
+			store.mu.Unlock()
+			return nil
+		},
+	}, nil
+}
+
+// clone copies the maps of every table. Stored records are never mutated in place, only
+// replaced, so the records themselves can be shared between the copies.
+func (t memoryTables) clone() memoryTables {
+	c := t
+
+	c.movies = make(map[int64]*Movie, len(t.movies))
+	for id, movie := range t.movies {
+		c.movies[id] = movie
+	}
+
+	c.users = make(map[int64]*User, len(t.users))
+	for id, user := range t.users {
+		c.users[id] = user
+	}
+
+	c.tokens = make(map[[sha256.Size]byte]*Token, len(t.tokens))
+	for hash, token := range t.tokens {
+		c.tokens[hash] = token
+	}
+
+	c.permissions = append([]*Permission(nil), t.permissions...)
+
+	c.userPermissions = make(map[int64]map[string]bool, len(t.userPermissions))
+	for userID, codes := range t.userPermissions {
+		c.userPermissions[userID] = make(map[string]bool, len(codes))
+		for code := range codes {
+			c.userPermissions[userID][code] = true
+		}
+	}
+
+	c.roles = append([]*Role(nil), t.roles...)
+
+	c.userRoles = make(map[int64]map[string]bool, len(t.userRoles))
+	for userID, names := range t.userRoles {
+		c.userRoles[userID] = make(map[string]bool, len(names))
+		for name := range names {
+			c.userRoles[userID][name] = true
+		}
+	}
+
+	c.revokedTokens = make(map[string]time.Time, len(t.revokedTokens))
+	for id, expiry := range t.revokedTokens {
+		c.revokedTokens[id] = expiry
+	}
+
+	c.revokedUserTokens = make(map[int64]RevokedUserTokens, len(t.revokedUserTokens))
+	for userID, tokens := range t.revokedUserTokens {
+		c.revokedUserTokens[userID] = tokens
+	}
+
+	c.apiKeys = make(map[string]*APIKey, len(t.apiKeys))
+	for id, key := range t.apiKeys {
+		c.apiKeys[id] = key
+	}
+
+	c.invitations = make(map[[sha256.Size]byte]*Invitation, len(t.invitations))
+	for hash, invitation := range t.invitations {
+		c.invitations[hash] = invitation
+	}
+
+	c.loginAttempts = append([]memoryLoginAttempt(nil), t.loginAttempts...)
+
+	c.auditEvents = append([]*AuditEvent(nil), t.auditEvents...)
+
+	c.mfa = make(map[int64]*MFA, len(t.mfa))
+	for userID, mfa := range t.mfa {
+		c.mfa[userID] = mfa
+	}
+
+	c.recoveryCodes = make(map[int64]map[[sha256.Size]byte]bool, len(t.recoveryCodes))
+	for userID, hashes := range t.recoveryCodes {
+		c.recoveryCodes[userID] = make(map[[sha256.Size]byte]bool, len(hashes))
+		for hash := range hashes {
+			c.recoveryCodes[userID][hash] = true
+		}
+	}
+
+	return c
+}
+
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Genres = append([]string(nil), movie.Genres...)
+	return &c
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	c.Password.plaintext = nil
+	c.Password.hash = append([]byte(nil), user.Password.hash...)
+	if user.ScheduledDeletionAt != nil {
+		t := *user.ScheduledDeletionAt
+		c.ScheduledDeletionAt = &t
+	}
+	if user.DisabledAt != nil {
+		t := *user.DisabledAt
+		c.DisabledAt = &t
+	}
+	return &c
+}
+
+func copyAPIKey(key *APIKey) *APIKey {
+	c := *key
+	c.Plaintext = ""
+	c.Permissions = append(Permissions(nil), key.Permissions...)
+	c.AllowedIPs = append([]string{}, key.AllowedIPs...)
+	return &c
+}
+
+func copyAuditEvent(event *AuditEvent) *AuditEvent {
+	c := *event
+	c.Details = make(map[string]string, len(event.Details))
+	for k, v := range event.Details {
+		c.Details[k] = v
+	}
+	return &c
+}
+
+func copyRole(role *Role) *Role {
+	c := *role
+	c.Permissions = append(Permissions{}, role.Permissions...)
+	return &c
+}
+
+// matchesTitle approximates plainto_tsquery: every word of the query has to appear as a
+// word in the title, ignoring case and punctuation.
+func matchesTitle(title, query string) bool {
+	splitWords := func(s string) []string {
+		return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+	}
+
+	words := make(map[string]bool)
+	for _, word := range splitWords(title) {
+		words[word] = true
+	}
+
+	for _, word := range splitWords(query) {
+		if !words[word] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsAll(values, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range values {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (m memoryAuditEventModel) Insert(event *AuditEvent) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+
+	m.store.lastAuditEventID++
+	event.ID = m.store.lastAuditEventID
+	event.CreatedAt = time.Now().Truncate(time.Second)
+
+	m.store.auditEvents = append(m.store.auditEvents, copyAuditEvent(event))
+	return nil
+}
+
+func (m memoryAuditEventModel) GetAll(filter AuditEventFilter, filters FilterOptions) ([]*AuditEvent, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	matches := func(want, got *int64) bool {
+		return want == nil || (got != nil && *got == *want)
+	}
+
+	matched := []*AuditEvent{}
+	for _, event := range m.store.auditEvents {
+		if filter.Action != "" && event.Action != filter.Action {
+			continue
+		}
+		if !matches(filter.ActorID, event.ActorID) || !matches(filter.SubjectID, event.SubjectID) {
+			continue
+		}
+		if filter.IP != "" && event.IP != filter.IP {
+			continue
+		}
+		if filter.RequestID != "" && event.RequestID != filter.RequestID {
+			continue
+		}
+		if filter.Since != nil && event.CreatedAt.Before(*filter.Since) {
+			continue
+		}
+		if filter.Until != nil && !event.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		matched = append(matched, event)
+	}
+
+	column, descending := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		var cmp int
+		switch column {
+		case "action":
+			cmp = strings.Compare(a.Action, b.Action)
+		case "created_at":
+			cmp = a.CreatedAt.Compare(b.CreatedAt)
+		}
+
+		if cmp == 0 {
+			if column != "id" {
+				return a.ID > b.ID
+			}
+			cmp = int(a.ID - b.ID)
+		}
+
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	totalRecords := len(matched)
+	events := []*AuditEvent{}
+
+	for i := filters.offset(); i < totalRecords && len(events) < filters.limit(); i++ {
+		events = append(events, copyAuditEvent(matched[i]))
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+func (m memoryAuditEventModel) GetAllForUser(userID int64) ([]*AuditEvent, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	events := []*AuditEvent{}
+
+	for _, event := range m.store.auditEvents {
+		if (event.ActorID != nil && *event.ActorID == userID) || (event.SubjectID != nil && *event.SubjectID == userID) {
+			events = append(events, copyAuditEvent(event))
+		}
+	}
+
+	return events, nil
+}
+
+func (m memoryAPIKeyModel) Insert(key *APIKey) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.apiKeys[key.ID] = copyAPIKey(key)
+	return nil
+}
+
+func (m memoryAPIKeyModel) Get(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, key := range m.store.apiKeys {
+		if !bytes.Equal(key.Hash, hash[:]) {
+			continue
+		}
+
+		if key.Expiry != nil && !key.Expiry.After(time.Now()) {
+			break
+		}
+
+		return copyAPIKey(key), nil
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryAPIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	keys := []*APIKey{}
+
+	for _, key := range m.store.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (m memoryAPIKeyModel) Touch(id string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	key, ok := m.store.apiKeys[id]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now().Truncate(time.Second)
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < time.Minute {
+		return nil
+	}
+
+	c := copyAPIKey(key)
+	c.LastUsedAt = &now
+	m.store.apiKeys[id] = c
+	return nil
+}
+
+func (m memoryAPIKeyModel) Delete(userID int64, id string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	key, ok := m.store.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.apiKeys, id)
+	return nil
+}
+
+func (m memoryAPIKeyModel) DeleteAllForUser(userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for id, key := range m.store.apiKeys {
+		if key.UserID == userID {
+			delete(m.store.apiKeys, id)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryInvitationModel) Insert(invitation *Invitation) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastInvitationID++
+	invitation.ID = m.store.lastInvitationID
+	invitation.CreatedAt = time.Now().Truncate(time.Second)
+
+	stored := *invitation
+	stored.Plaintext = ""
+	stored.Permissions = append(Permissions{}, invitation.Permissions...)
+
+	m.store.invitations[sha256.Sum256([]byte(invitation.Plaintext))] = &stored
+	return nil
+}
+
+func (m memoryInvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	invitation, found := m.store.invitations[sha256.Sum256([]byte(tokenPlaintext))]
+	if !found || !invitation.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	c := *invitation
+	c.Permissions = append(Permissions{}, invitation.Permissions...)
+	return &c, nil
+}
+
+func (m memoryInvitationModel) GetAllCreatedBy(userID int64) ([]*Invitation, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	invitations := []*Invitation{}
+
+	for _, invitation := range m.store.invitations {
+		if invitation.CreatedBy != nil && *invitation.CreatedBy == userID {
+			c := *invitation
+			c.Permissions = append(Permissions{}, invitation.Permissions...)
+			invitations = append(invitations, &c)
+		}
+	}
+
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].ID < invitations[j].ID
+	})
+
+	return invitations, nil
+}
+
+func (m memoryInvitationModel) Delete(id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, invitation := range m.store.invitations {
+		if invitation.ID == id {
+			delete(m.store.invitations, hash)
+			return nil
+		}
+	}
+
+	return ErrRecordNotFound
+}
+
+func (m memoryInvitationModel) DeleteAllForEmail(email string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, invitation := range m.store.invitations {
+		if strings.EqualFold(invitation.Email, email) {
+			delete(m.store.invitations, hash)
+		}
+	}
+
+	return nil
+}
+
+// TryLock takes the lock called name unless it is already taken. Like the SQL model it
+// can't be used inside a transaction.
+func (m memoryLockModel) TryLock(name string) (func() error, bool, error) {
+	if m.store.locks == nil {
+		return nil, false, ErrTxInProgress
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.locks[name] {
+		return nil, false, nil
+	}
+
+	m.store.locks[name] = true
+
+	unlock := func() error {
+		m.store.mu.Lock()
+		defer m.store.mu.Unlock()
+
+		delete(m.store.locks, name)
+		return nil
+	}
+
+	return unlock, true, nil
+}
+
+func (m memoryLoginAttemptModel) InsertFailure(email, ip string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.loginAttempts = append(m.store.loginAttempts, memoryLoginAttempt{
+		email:     email,
+		ip:        ip,
+		createdAt: time.Now().Truncate(time.Second),
+	})
+	return nil
+}
+
+func (m memoryLoginAttemptModel) GetAllForEmail(email string) ([]*LoginAttempt, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	attempts := []*LoginAttempt{}
+
+	for _, attempt := range m.store.loginAttempts {
+		if strings.EqualFold(attempt.email, email) {
+			attempts = append(attempts, &LoginAttempt{Email: attempt.email, IP: attempt.ip, CreatedAt: attempt.createdAt})
+		}
+	}
+
+	return attempts, nil
+}
+
+func (m memoryLoginAttemptModel) FailuresForEmail(email string, since time.Time) (LoginFailures, error) {
+	return m.failures(since, func(attempt memoryLoginAttempt) bool {
+		return strings.EqualFold(attempt.email, email)
+	}), nil
+}
+
+func (m memoryLoginAttemptModel) FailuresForIP(ip string, since time.Time) (LoginFailures, error) {
+	return m.failures(since, func(attempt memoryLoginAttempt) bool {
+		return attempt.ip == ip
+	}), nil
+}
+
+func (m memoryLoginAttemptModel) failures(since time.Time, match func(memoryLoginAttempt) bool) LoginFailures {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	failures := LoginFailures{Last: time.Unix(0, 0)}
+
+	for _, attempt := range m.store.loginAttempts {
+		if !attempt.createdAt.After(since) || !match(attempt) {
+			continue
+		}
+
+		failures.Count++
+		if attempt.createdAt.After(failures.Last) {
+			failures.Last = attempt.createdAt
+		}
+	}
+
+	return failures
+}
+
+func (m memoryLoginAttemptModel) DeleteForEmail(email string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	kept := m.store.loginAttempts[:0:0]
+	for _, attempt := range m.store.loginAttempts {
+		if !strings.EqualFold(attempt.email, email) {
+			kept = append(kept, attempt)
+		}
+	}
+
+	m.store.loginAttempts = kept
+	return nil
+}
+
+func (m memoryLoginAttemptModel) DeleteBefore(before time.Time) (int64, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	kept := m.store.loginAttempts[:0:0]
+	for _, attempt := range m.store.loginAttempts {
+		if !attempt.createdAt.Before(before) {
+			kept = append(kept, attempt)
+		}
+	}
+
+	deleted := int64(len(m.store.loginAttempts) - len(kept))
+	m.store.loginAttempts = kept
+	return deleted, nil
+}
+
+func copyMFA(mfa *MFA) *MFA {
+	c := *mfa
+	c.Secret = append([]byte(nil), mfa.Secret...)
+	if mfa.ConfirmedAt != nil {
+		t := *mfa.ConfirmedAt
+		c.ConfirmedAt = &t
+	}
+	return &c
+}
+
+func (m memoryMFAModel) Get(userID int64) (*MFA, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	mfa, ok := m.store.mfa[userID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMFA(mfa), nil
+}
+
+func (m memoryMFAModel) Insert(mfa *MFA) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if existing, ok := m.store.mfa[mfa.UserID]; ok && existing.Enabled() {
+		return ErrEditConflict
+	}
+
+	mfa.CreatedAt = time.Now().Truncate(time.Second)
+	mfa.ConfirmedAt = nil
+	mfa.LastUsedCounter = 0
+
+	m.store.mfa[mfa.UserID] = copyMFA(mfa)
+	return nil
+}
+
+func (m memoryMFAModel) Confirm(userID int64, counter int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	mfa, ok := m.store.mfa[userID]
+	if !ok || mfa.Enabled() {
+		return ErrEditConflict
+	}
+
+	now := time.Now().Truncate(time.Second)
+
+	c := copyMFA(mfa)
+	c.ConfirmedAt = &now
+	c.LastUsedCounter = counter
+	m.store.mfa[userID] = c
+	return nil
+}
+
+func (m memoryMFAModel) UseCounter(userID int64, counter int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	mfa, ok := m.store.mfa[userID]
+	if !ok || mfa.LastUsedCounter >= counter {
+		return ErrEditConflict
+	}
+
+	c := copyMFA(mfa)
+	c.LastUsedCounter = counter
+	m.store.mfa[userID] = c
+	return nil
+}
+
+func (m memoryMFAModel) Delete(userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	delete(m.store.mfa, userID)
+	delete(m.store.recoveryCodes, userID)
+	return nil
+}
+
+func (m memoryMFAModel) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hashes := make(map[[sha256.Size]byte]bool, len(codes))
+	for _, code := range codes {
+		hashes[[sha256.Size]byte(hashRecoveryCode(code))] = true
+	}
+
+	m.store.recoveryCodes[userID] = hashes
+	return nil
+}
+
+func (m memoryMFAModel) UseRecoveryCode(userID int64, code string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hash := [sha256.Size]byte(hashRecoveryCode(code))
+
+	if !m.store.recoveryCodes[userID][hash] {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.recoveryCodes[userID], hash)
+	return nil
+}
+
+func (m memoryMFAModel) CountRecoveryCodes(userID int64) (int, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return len(m.store.recoveryCodes[userID]), nil
+}
+
+func (m memoryMovieModel) Insert(movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastMovieID++
+	movie.ID = m.store.lastMovieID
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.Version = 1
+
+	m.store.movies[movie.ID] = copyMovie(movie)
+	return nil
+}
+
+func (m memoryMovieModel) Get(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+func (m memoryMovieModel) GetAll(title string, genres []string, filters FilterOptions) ([]*Movie, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	matched := []*Movie{}
+	for _, movie := range m.store.movies {
+		if title != "" && !matchesTitle(movie.Title, title) {
+			continue
+		}
+		if !containsAll(movie.Genres, genres) {
+			continue
+		}
+		matched = append(matched, movie)
+	}
+
+	column, descending := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		var cmp int
+		switch column {
+		case "title":
+			cmp = strings.Compare(a.Title, b.Title)
+		case "year":
+			cmp = int(a.Year - b.Year)
+		case "runtime":
+			cmp = int(a.Runtime - b.Runtime)
+		}
+
+		if cmp == 0 {
+			cmp = int(a.ID - b.ID)
+			if column != "id" {
+				return cmp < 0
+			}
+		}
+
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	totalRecords := len(matched)
+	movies := []*Movie{}
+
+	for i := filters.offset(); i < totalRecords && len(movies) < filters.limit(); i++ {
+		movies = append(movies, copyMovie(matched[i]))
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+func (m memoryMovieModel) GetAllCreatedBy(userID int64) ([]*Movie, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movies := []*Movie{}
+
+	for _, movie := range m.store.movies {
+		if movie.CreatedBy != nil && *movie.CreatedBy == userID {
+			movies = append(movies, copyMovie(movie))
+		}
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		return movies[i].ID < movies[j].ID
+	})
+
+	return movies, nil
+}
+
+func (m memoryMovieModel) Update(movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	existing, ok := m.store.movies[movie.ID]
+	if !ok || existing.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	m.store.movies[movie.ID] = copyMovie(movie)
+	return nil
+}
+
+func (m memoryMovieModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.movies[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.movies, id)
+	return nil
+}
+
+// Notify does nothing: the in-memory store only ever serves a single instance, so there
+// is nobody to notify.
+func (m memoryNotificationModel) Notify(channel, payload string) error {
+	return nil
+}
+
+func (m memoryPermissionModel) GetAll() ([]*Permission, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	permissions := []*Permission{}
+	for _, permission := range m.store.permissions {
+		if !IsPermissionPattern(permission.Code) {
+			permissions = append(permissions, &Permission{
+				Code:        permission.Code,
+				Description: permission.Description,
+				Implies:     Implied(permission.Code),
+			})
+		}
+	}
+
+	return permissions, nil
+}
+
+func (m memoryPermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	granted := make(map[string]bool)
+	for code := range m.store.userPermissions[userID] {
+		granted[code] = true
+	}
+	for _, role := range m.store.roles {
+		if m.store.userRoles[userID][role.Name] {
+			for _, code := range role.Permissions {
+				granted[code] = true
+			}
+		}
+	}
+
+	var permissions Permissions
+
+	for _, permission := range m.store.permissions {
+		if granted[permission.Code] {
+			permissions = append(permissions, permission.Code)
+		}
+	}
+
+	return permissions, nil
+}
+
+func (m memoryPermissionModel) AddForUser(userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.userPermissions[userID] == nil {
+		m.store.userPermissions[userID] = make(map[string]bool)
+	}
+
+	m.store.insertPermissionPatterns(codes)
+
+	for _, code := range codes {
+		if m.store.hasPermission(code) {
+			m.store.userPermissions[userID][code] = true
+		}
+	}
+
+	return nil
+}
+
+func (m memoryPermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		delete(m.store.userPermissions[userID], code)
+	}
+
+	return nil
+}
+
+func (t *memoryTables) hasPermission(code string) bool {
+	for _, permission := range t.permissions {
+		if permission.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// insertPermissionPatterns mirrors PermissionModel's insertion of the wildcard patterns
+// being granted. The caller must hold the store's lock.
+func (t *memoryTables) insertPermissionPatterns(codes []string) {
+	for _, code := range codes {
+		if IsPermissionPattern(code) && !t.hasPermission(code) {
+			t.permissions = append(t.permissions, &Permission{Code: code})
+		}
+	}
+}
+
+func (m memoryRoleModel) Insert(role *Role) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, existing := range m.store.roles {
+		if existing.Name == role.Name {
+			return ErrDuplicateRoleName
+		}
+	}
+
+	m.store.lastRoleID++
+	role.ID = m.store.lastRoleID
+	role.CreatedAt = time.Now().Truncate(time.Second)
+
+	m.store.insertPermissionPatterns(role.Permissions)
+
+	stored := *role
+	stored.Permissions = nil
+	for _, permission := range m.store.permissions {
+		for _, code := range role.Permissions {
+			if code == permission.Code {
+				stored.Permissions = append(stored.Permissions, code)
+			}
+		}
+	}
+
+	m.store.roles = append(m.store.roles, &stored)
+	return nil
+}
+
+func (m memoryRoleModel) Get(name string) (*Role, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, role := range m.store.roles {
+		if role.Name == name {
+			return copyRole(role), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryRoleModel) GetAll() ([]*Role, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	roles := make([]*Role, len(m.store.roles))
+	for i, role := range m.store.roles {
+		roles[i] = copyRole(role)
+	}
+
+	return roles, nil
+}
+
+func (m memoryRoleModel) GetAllForUser(userID int64) ([]string, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	names := []string{}
+	for name := range m.store.userRoles[userID] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m memoryRoleModel) AddForUser(userID int64, names ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.userRoles[userID] == nil {
+		m.store.userRoles[userID] = make(map[string]bool)
+	}
+
+	for _, name := range names {
+		for _, role := range m.store.roles {
+			if name == role.Name {
+				m.store.userRoles[userID][name] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m memoryRoleModel) RemoveForUser(userID int64, names ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, name := range names {
+		delete(m.store.userRoles[userID], name)
+	}
+
+	return nil
+}
+
+func (model memoryRevokedTokenModel) Insert(id string, expiry time.Time) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	if _, exists := model.store.revokedTokens[id]; !exists {
+		model.store.revokedTokens[id] = expiry
+	}
+
+	return nil
+}
+
+func (model memoryRevokedTokenModel) InsertForUser(userID int64, issuedBefore, expiry time.Time) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	tokens, exists := model.store.revokedUserTokens[userID]
+	if !exists {
+		tokens = RevokedUserTokens{UserID: userID}
+	}
+
+	if issuedBefore.After(tokens.IssuedBefore) {
+		tokens.IssuedBefore = issuedBefore
+	}
+
+	if expiry.After(tokens.Expiry) {
+		tokens.Expiry = expiry
+	}
+
+	model.store.revokedUserTokens[userID] = tokens
+	return nil
+}
+
+func (model memoryRevokedTokenModel) GetAllUnexpired() ([]*RevokedToken, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	revoked := []*RevokedToken{}
+
+	for id, expiry := range model.store.revokedTokens {
+		if expiry.After(time.Now()) {
+			revoked = append(revoked, &RevokedToken{ID: id, Expiry: expiry})
+		}
+	}
+
+	return revoked, nil
+}
+
+func (model memoryRevokedTokenModel) GetAllUnexpiredForUsers() ([]*RevokedUserTokens, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	revoked := []*RevokedUserTokens{}
+
+	for _, tokens := range model.store.revokedUserTokens {
+		if tokens.Expiry.After(time.Now()) {
+			t := tokens
+			revoked = append(revoked, &t)
+		}
+	}
+
+	return revoked, nil
+}
+
+func (model memoryRevokedTokenModel) DeleteExpired(limit int) (int64, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	var tokens, users int64
+	now := time.Now()
+
+	for id, expiry := range model.store.revokedTokens {
+		if tokens >= int64(limit) {
+			break
+		}
+
+		if expiry.Before(now) {
+			delete(model.store.revokedTokens, id)
+			tokens++
+		}
+	}
+
+	for userID, revoked := range model.store.revokedUserTokens {
+		if users >= int64(limit) {
+			break
+		}
+
+		if revoked.Expiry.Before(now) {
+			delete(model.store.revokedUserTokens, userID)
+			users++
+		}
+	}
+
+	return tokens + users, nil
+}
+
+func (model memoryTokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return model.NewWithPayload(userID, ttl, scope, "")
+}
+
+func (model memoryTokenModel) NewWithPayload(userID int64, ttl time.Duration, scope, payload string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Payload = payload
+
+	err = model.Insert(token)
+	return token, err
+}
+
+func (model memoryTokenModel) Insert(token *Token) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	var hash [sha256.Size]byte
+	copy(hash[:], token.Hash)
+
+	c := *token
+	c.Plaintext = ""
+	model.store.tokens[hash] = &c
+	return nil
+}
+
+func (model memoryTokenModel) Get(tokenScope, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	token, ok := model.store.tokens[tokenHash]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	c := *token
+	return &c, nil
+}
+
+func (model memoryTokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	tokens := []*Token{}
+
+	for _, token := range model.store.tokens {
+		if token.UserID == userID && token.Expiry.After(time.Now()) {
+			c := *token
+			tokens = append(tokens, &c)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Expiry.Equal(tokens[j].Expiry) {
+			return bytes.Compare(tokens[i].Hash, tokens[j].Hash) < 0
+		}
+		return tokens[i].Expiry.Before(tokens[j].Expiry)
+	})
+
+	return tokens, nil
+}
+
+func (model memoryTokenModel) Touch(tokenScope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	token, ok := model.store.tokens[tokenHash]
+	if !ok || token.Scope != tokenScope {
+		return nil
+	}
+
+	now := time.Now().Truncate(time.Second)
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < time.Minute {
+		return nil
+	}
+
+	c := *token
+	c.LastUsedAt = &now
+	model.store.tokens[tokenHash] = &c
+	return nil
+}
+
+func (model memoryTokenModel) MarkUsed(tokenScope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	token, ok := model.store.tokens[tokenHash]
+	if !ok || token.Scope != tokenScope || token.UsedAt != nil {
+		return ErrEditConflict
+	}
+
+	now := time.Now().Truncate(time.Second)
+
+	c := *token
+	c.UsedAt = &now
+	model.store.tokens[tokenHash] = &c
+	return nil
+}
+
+func (model memoryTokenModel) Delete(tokenScope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	if token, ok := model.store.tokens[tokenHash]; ok && token.Scope == tokenScope {
+		delete(model.store.tokens, tokenHash)
+	}
+
+	return nil
+}
+
+func (model memoryTokenModel) DeleteByID(userID int64, id string) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	var match *Token
+	for _, token := range model.store.tokens {
+		if token.UserID == userID && token.ID == id {
+			match = token
+			break
+		}
+	}
+
+	if match == nil {
+		return ErrRecordNotFound
+	}
+
+	for hash, token := range model.store.tokens {
+		if token == match || (match.Family != "" && token.Family == match.Family) {
+			delete(model.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (model memoryTokenModel) DeleteFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	for hash, token := range model.store.tokens {
+		if token.Family == family {
+			delete(model.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (model memoryTokenModel) DeleteForFamily(scope, family string) error {
+	if family == "" {
+		return nil
+	}
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	for hash, token := range model.store.tokens {
+		if token.Scope == scope && token.Family == family {
+			delete(model.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (model memoryTokenModel) DeleteAllForUser(scope string, userID int64) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	for hash, token := range model.store.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(model.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (model memoryTokenModel) DeleteAllScopesForUser(userID int64) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	for hash, token := range model.store.tokens {
+		if token.UserID == userID {
+			delete(model.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (model memoryTokenModel) DeleteExpired(limit int) (int64, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+
+	for hash, token := range model.store.tokens {
+		if deleted >= int64(limit) {
+			break
+		}
+
+		if token.Expiry.Before(now) {
+			delete(model.store.tokens, hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (model memoryUserModel) emailTaken(email string, exceptID int64) bool {
+	for _, user := range model.store.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (model memoryUserModel) Insert(user *User) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	if model.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	model.store.lastUserID++
+	user.ID = model.store.lastUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	model.store.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (model memoryUserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	token, ok := model.store.tokens[tokenHash]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := model.store.users[token.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (model memoryUserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	user, ok := model.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (model memoryUserModel) GetByEmail(email string) (*User, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	for _, user := range model.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (model memoryUserModel) GetAll(search string, activated *bool, filters FilterOptions) ([]*User, Metadata, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	search = strings.ToLower(search)
+
+	matched := []*User{}
+	for _, user := range model.store.users {
+		if search != "" && !strings.Contains(strings.ToLower(user.Email), search) && !strings.Contains(strings.ToLower(user.Name), search) {
+			continue
+		}
+		if activated != nil && user.Activated != *activated {
+			continue
+		}
+		matched = append(matched, user)
+	}
+
+	column, descending := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		var cmp int
+		switch column {
+		case "email":
+			cmp = strings.Compare(strings.ToLower(a.Email), strings.ToLower(b.Email))
+		case "name":
+			cmp = strings.Compare(a.Name, b.Name)
+		case "created_at":
+			cmp = a.CreatedAt.Compare(b.CreatedAt)
+		}
+
+		if cmp == 0 {
+			cmp = int(a.ID - b.ID)
+			if column != "id" {
+				return cmp < 0
+			}
+		}
+
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	totalRecords := len(matched)
+	users := []*User{}
+
+	for i := filters.offset(); i < totalRecords && len(users) < filters.limit(); i++ {
+		users = append(users, copyUser(matched[i]))
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+func (model memoryUserModel) Update(user *User) error {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	// A stale version matches no row in Postgres, so it is reported before the email.
+	existing, ok := model.store.users[user.ID]
+	if !ok || existing.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if model.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	model.store.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (model memoryUserModel) DeleteScheduled(before time.Time) (int64, error) {
+	model.store.mu.Lock()
+	defer model.store.mu.Unlock()
+
+	var deleted int64
+
+	for id, user := range model.store.users {
+		if user.ScheduledDeletionAt == nil || user.ScheduledDeletionAt.After(before) {
+			continue
+		}
+
+		// Mirror the ON DELETE CASCADE foreign keys of the tokens,
+		// users_permissions, users_roles, api_keys, revoked_user_access_tokens and MFA
+		// tables, and the ON DELETE SET NULL of the movies' created_by and updated_by.
+		for hash, token := range model.store.tokens {
+			if token.UserID == id {
+				delete(model.store.tokens, hash)
+			}
+		}
+		delete(model.store.userPermissions, id)
+		delete(model.store.userRoles, id)
+		for keyID, key := range model.store.apiKeys {
+			if key.UserID == id {
+				delete(model.store.apiKeys, keyID)
+			}
+		}
+		delete(model.store.revokedUserTokens, id)
+		delete(model.store.mfa, id)
+		delete(model.store.recoveryCodes, id)
+		for movieID, movie := range model.store.movies {
+			c := copyMovie(movie)
+			if c.CreatedBy != nil && *c.CreatedBy == id {
+				c.CreatedBy = nil
+			}
+			if c.UpdatedBy != nil && *c.UpdatedBy == id {
+				c.UpdatedBy = nil
+			}
+			model.store.movies[movieID] = c
+		}
+
+		delete(model.store.users, id)
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransactionIsolation(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish func(tx *Tx) error
+		// wantTxWrite is whether the transaction's own write survives.
+		wantTxWrite bool
+	}{
+		{name: "commit", finish: (*Tx).Commit, wantTxWrite: true},
+		{name: "rollback", finish: (*Tx).Rollback, wantTxWrite: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := NewMemoryModels()
+
+			tx, err := models.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tx.Movies.Insert(&Movie{Title: "In the transaction"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A write from outside the transaction, like a failed sign in being
+			// recorded, has to wait for it and must survive however it ends.
+			outside := make(chan error, 1)
+			go func() {
+				err := models.LoginAttempts.InsertFailure("alice@example.com", "192.0.2.1")
+				if err != nil {
+					outside <- err
+					return
+				}
+
+				_, err = models.Movies.Get(1)
+				outside <- err
+			}()
+
+			select {
+			case <-outside:
+				t.Fatal("a statement outside the transaction didn't wait for it")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			err = tt.finish(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The outside read ran after the transaction finished, so it finds the
+			// movie only if it was committed.
+			err = <-outside
+			if found := err == nil; found != tt.wantTxWrite {
+				t.Errorf("outside read got %v; want the transaction's write: %t", err, tt.wantTxWrite)
+			}
+
+			failures, err := models.LoginAttempts.FailuresForEmail("alice@example.com", time.Now().Add(-time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failures.Count != 1 {
+				t.Errorf("got %d failed sign ins; want the outside write to survive", failures.Count)
+			}
+		})
+	}
+}
+
+func TestMemoryTransactionIsReleasedAfterError(t *testing.T) {
+	models := NewMemoryModels()
+	errFailed := errors.New("failed")
+
+	err := models.Transaction(func(tx Models) error {
+		err := tx.Movies.Insert(&Movie{Title: "Rolled back"})
+		if err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("got %v; want the function's error", err)
+	}
+
+	_, err = models.Movies.Get(1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v; want the rolled back movie to be gone", err)
+	}
+}
+
+func TestMemoryUserUpdateReportsConflictFirst(t *testing.T) {
+	models := NewMemoryModels()
+
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		err := models.Users.Insert(&User{Name: "User", Email: email})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user, err := models.Users.GetByEmail("second@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		email   string
+		version int
+		wantErr error
+	}{
+		{name: "stale version and taken email", email: "first@example.com", version: user.Version - 1, wantErr: ErrEditConflict},
+		{name: "taken email", email: "first@example.com", version: user.Version, wantErr: ErrDuplicateEmail},
+		{name: "stale version", email: "second@example.com", version: user.Version + 1, wantErr: ErrEditConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := *user
+			update.Email = tt.email
+			update.Version = tt.version
+
+			err := models.Users.Update(&update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
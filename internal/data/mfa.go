@@ -0,0 +1,244 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+type (
+	// MFA is a user's TOTP enrolment. Secret holds the encrypted seed; it is never
+	// stored in the clear. Two-factor authentication is only enforced once the
+	// enrolment has been confirmed with a code from the user's app.
+	MFA struct {
+		UserID          int64
+		Secret          []byte
+		ConfirmedAt     *time.Time
+		LastUsedCounter int64
+		CreatedAt       time.Time
+	}
+
+	IMFAModel interface {
+		Get(userID int64) (*MFA, error)
+		Insert(mfa *MFA) error
+		Confirm(userID int64, counter int64) error
+		UseCounter(userID int64, counter int64) error
+		Delete(userID int64) error
+		ReplaceRecoveryCodes(userID int64, codes []string) error
+		UseRecoveryCode(userID int64, code string) error
+		CountRecoveryCodes(userID int64) (int, error)
+	}
+)
+
+func (mfa *MFA) Enabled() bool {
+	return mfa.ConfirmedAt != nil
+}
+
+// GenerateRecoveryCodes returns a new set of one-time recovery codes, formatted as two
+// groups of five hex digits.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes, so codes can be typed the way they
+// were written down.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+func (model MFAModel) Get(userID int64) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_counter, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	var mfa MFA
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.ConfirmedAt,
+		&mfa.LastUsedCounter,
+		&mfa.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, mapError(err)
+		}
+	}
+
+	return &mfa, nil
+}
+
+// Insert starts an enrolment, replacing any earlier one that was never confirmed.
+func (model MFAModel) Insert(mfa *MFA) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_counter = 0
+		WHERE user_mfa.confirmed_at IS NULL
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, mfa.UserID, mfa.Secret).Scan(&mfa.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return mapError(err)
+		}
+	}
+
+	return nil
+}
+
+// Confirm enables two-factor authentication, recording the counter of the code that
+// confirmed it so that code can't be used to sign in.
+func (model MFAModel) Confirm(userID int64, counter int64) error {
+	query := `
+		UPDATE user_mfa
+		SET confirmed_at = NOW(), last_used_counter = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`
+
+	return model.exec(query, userID, counter)
+}
+
+// UseCounter records that the code for counter has been used. It returns
+// ErrEditConflict if that code, or a later one, was used already.
+func (model MFAModel) UseCounter(userID int64, counter int64) error {
+	query := `
+		UPDATE user_mfa
+		SET last_used_counter = $2
+		WHERE user_id = $1 AND last_used_counter < $2`
+
+	return model.exec(query, userID, counter)
+}
+
+func (model MFAModel) exec(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return mapError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Delete turns two-factor authentication off, removing the recovery codes with it.
+func (model MFAModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return mapError(err)
+	}
+
+	_, err = model.DB.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	return mapError(err)
+}
+
+func (model MFAModel) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return mapError(err)
+	}
+
+	for _, code := range codes {
+		_, err = model.DB.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return mapError(err)
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. It returns ErrRecordNotFound
+// if the code doesn't exist or has been used already.
+func (model MFAModel) UseRecoveryCode(userID int64, code string) error {
+	query := `
+		DELETE FROM mfa_recovery_codes
+		WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return mapError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (model MFAModel) CountRecoveryCodes(userID int64) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM mfa_recovery_codes
+		WHERE user_id = $1`
+
+	var count int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, mapError(err)
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMFAUseCounterRefusesReplay(t *testing.T) {
+	models := NewMemoryModels()
+
+	err := models.MFA.Insert(&MFA{UserID: 1, Secret: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The code that confirmed the enrolment counts as used.
+	err = models.MFA.Confirm(1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		counter int64
+		wantErr error
+	}{
+		{name: "code that confirmed the enrolment", counter: 100, wantErr: ErrEditConflict},
+		{name: "older code", counter: 99, wantErr: ErrEditConflict},
+		{name: "next code", counter: 101},
+		{name: "same code again", counter: 101, wantErr: ErrEditConflict},
+		{name: "code skipped ahead", counter: 103},
+		{name: "code from before the skip", counter: 102, wantErr: ErrEditConflict},
+	}
+
+	// The steps depend on each other, so they run in order against the same store.
+	for _, step := range steps {
+		err := models.MFA.UseCounter(1, step.counter)
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("%s: UseCounter(%d) = %v; want %v", step.name, step.counter, err, step.wantErr)
+		}
+	}
+
+	if err := models.MFA.UseCounter(2, 1); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("UseCounter for a user without MFA = %v; want ErrEditConflict", err)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	models := NewMemoryModels()
+
+	err := models.MFA.ReplaceRecoveryCodes(1, []string{"aaaa-bbbb", "cccc-dddd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		code      string
+		wantErr   error
+		wantCount int
+	}{
+		{code: "aaaa-bbbb", wantCount: 1},
+		{code: "aaaa-bbbb", wantErr: ErrRecordNotFound, wantCount: 1},
+		{code: "eeee-ffff", wantErr: ErrRecordNotFound, wantCount: 1},
+		{code: "cccc-dddd", wantCount: 0},
+	}
+
+	for _, step := range steps {
+		err := models.MFA.UseRecoveryCode(1, step.code)
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("UseRecoveryCode(%q) = %v; want %v", step.code, err, step.wantErr)
+		}
+
+		count, err := models.MFA.CountRecoveryCodes(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != step.wantCount {
+			t.Errorf("after %q: %d codes left; want %d", step.code, count, step.wantCount)
+		}
+	}
+}
@@ -1,23 +1,146 @@
 package data
 
-import "database/sql"
-
-type MovieModel struct{ DB *sql.DB }
-type PermissionModel struct{ DB *sql.DB }
-type TokenModel struct{ DB *sql.DB }
-type UserModel struct{ DB *sql.DB }
-
-type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Token       TokenModel
-	Users       UserModel
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrTxInProgress = errors.New("transaction already in progress")
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so the same model can run its queries
+// either directly against the pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
+type APIKeyModel struct{ DB DBTX }
+type AuditEventModel struct{ DB DBTX }
+type InvitationModel struct{ DB DBTX }
+type LockModel struct{ DB DBTX }
+type LoginAttemptModel struct{ DB DBTX }
+type MFAModel struct{ DB DBTX }
+type MovieModel struct{ DB DBTX }
+type NotificationModel struct{ DB DBTX }
+type PermissionModel struct{ DB DBTX }
+type RevokedTokenModel struct{ DB DBTX }
+type RoleModel struct{ DB DBTX }
+type TokenModel struct{ DB DBTX }
+type UserModel struct{ DB DBTX }
+
+type (
+	Models struct {
+		APIKeys       IAPIKeyModel
+		AuditEvents   IAuditEventModel
+		Invitations   IInvitationModel
+		Locks         ILockModel
+		LoginAttempts ILoginAttemptModel
+		MFA           IMFAModel
+		Movies        IMovieModel
+		Notifications INotificationModel
+		Permissions   IPermissionModel
+		RevokedTokens IRevokedTokenModel
+		Roles         IRoleModel
+		Token         ITokenModel
+		Users         IUserModel
+
+		begin func() (*Tx, error)
+	}
+
+	// Tx is a Models whose every model is bound to the same transaction. It must be
+	// finished with either Commit or Rollback.
+	Tx struct {
+		Models
+		commit   func() error
+		rollback func() error
+		done     bool
+	}
+)
+
 func NewModels(db *sql.DB) Models {
+	models := newModels(db)
+
+	models.begin = func() (*Tx, error) {
+		sqlTx, err := db.BeginTx(context.Background(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Tx{
+			Models:   newModels(sqlTx),
+			commit:   sqlTx.Commit,
+			rollback: sqlTx.Rollback,
+		}, nil
+	}
+
+	return models
+}
+
+func newModels(db DBTX) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
-		Token:  TokenModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db},
+		AuditEvents:   AuditEventModel{DB: db},
+		Invitations:   InvitationModel{DB: db},
+		Locks:         LockModel{DB: db},
+		LoginAttempts: LoginAttemptModel{DB: db},
+		MFA:           MFAModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		RevokedTokens: RevokedTokenModel{DB: db},
+		Roles:         RoleModel{DB: db},
+		Users:         UserModel{DB: db},
+		Token:         TokenModel{DB: db},
+	}
+}
+
+// Begin starts a transaction. Models that are already bound to a transaction return
+// ErrTxInProgress.
+func (m Models) Begin() (*Tx, error) {
+	if m.begin == nil {
+		return nil, ErrTxInProgress
 	}
+
+	return m.begin()
+}
+
+// Transaction runs fn with a Models bound to a new transaction. The transaction is
+// committed when fn returns nil and rolled back otherwise, and fn's error is returned
+// unchanged so callers can keep matching on it with errors.Is.
+func (m Models) Transaction(fn func(tx Models) error) error {
+	tx, err := m.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(tx.Models)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+
+	tx.done = true
+	return tx.commit()
+}
+
+// Rollback aborts the transaction. Calling it after Commit is a no-op, so it is safe to
+// defer straight after Begin.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+
+	tx.done = true
+	return tx.rollback()
 }
@@ -24,12 +24,18 @@ type (
 		Runtime   Runtime   `json:"runtime,omitempty"`
 		Genres    []string  `json:"genres,omitempty"`
 		Version   int32     `json:"-"`
+		// CreatedBy and UpdatedBy are the IDs of the users who created the movie and
+		// last changed it. They are nil for movies that predate them and once the user
+		// has been deleted.
+		CreatedBy *int64 `json:"created_by,omitempty"`
+		UpdatedBy *int64 `json:"updated_by,omitempty"`
 	}
 
 	IMovieModel interface {
 		Insert(movie *Movie) error
 		Get(id int64) (*Movie, error)
-		GetAll(title string, genres []string, filters Filters) ([]*Movie, error)
+		GetAll(title string, genres []string, filters FilterOptions) ([]*Movie, Metadata, error)
+		GetAllCreatedBy(userID int64) ([]*Movie, error)
 		Update(movie *Movie) error
 		Delete(id int64) error
 	}
@@ -54,16 +60,17 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movies (title, year, runtime, genres, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, version`
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy, movie.UpdatedBy}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return mapError(err)
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
@@ -72,7 +79,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, title, year, runtime, genres, version, created_by, updated_by
 		FROM movies
 		WHERE id = $1`
 
@@ -90,6 +97,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.CreatedBy,
+		&movie.UpdatedBy,
 	)
 
 	if err != nil {
@@ -97,7 +106,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, mapError(err)
 		}
 	}
 
@@ -106,7 +115,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 func (m MovieModel) GetAll(title string, genres []string, filters FilterOptions) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-    	SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version
+    	SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version, created_by, updated_by
         FROM movies
         WHERE (to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '')
         AND (genres @> $2 OR $2 = '{}')
@@ -120,14 +129,14 @@ func (m MovieModel) GetAll(title string, genres []string, filters FilterOptions)
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, mapError(err)
 	}
 
 	defer rows.Close()
 
 	totalRecords := 0
 	movies := []*Movie{}
-	if rows.Next() {
+	for rows.Next() {
 		var movie Movie
 
 		err := rows.Scan(
@@ -139,16 +148,18 @@ func (m MovieModel) GetAll(title string, genres []string, filters FilterOptions)
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.CreatedBy,
+			&movie.UpdatedBy,
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, mapError(err)
 		}
 
 		movies = append(movies, &movie)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, mapError(err)
 	}
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
@@ -156,11 +167,58 @@ func (m MovieModel) GetAll(title string, genres []string, filters FilterOptions)
 	return movies, metadata, nil
 }
 
+// GetAllCreatedBy returns every movie the user created, oldest first.
+func (m MovieModel) GetAllCreatedBy(userID int64) ([]*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version, created_by, updated_by
+		FROM movies
+		WHERE created_by = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.UpdatedBy,
+		)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return movies, nil
+}
+
 func (m MovieModel) Update(movie *Movie) error {
 	query := `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version +  1
-		WHERE id = $5 and version = $6
+		SET title = $1, year = $2, runtime = $3, genres = $4, updated_by = $5, version = version +  1
+		WHERE id = $6 and version = $7
 		RETURNING version`
 
 	args := []any{
@@ -168,6 +226,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.UpdatedBy,
 		movie.ID,
 		movie.Version,
 	}
@@ -181,7 +240,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return mapError(err)
 		}
 	}
 
@@ -202,12 +261,12 @@ func (m MovieModel) Delete(id int64) error {
 
 	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		return err
+		return mapError(err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return mapError(err)
 	}
 
 	if rowsAffected == 0 {
@@ -0,0 +1,25 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// INotificationModel publishes Postgres notifications, which other instances receive
+	// by listening on the channel. Inside a transaction they are only delivered once it
+	// commits.
+	INotificationModel interface {
+		Notify(channel, payload string) error
+	}
+)
+
+func (m NotificationModel) Notify(channel, payload string) error {
+	query := `SELECT pg_notify($1, $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, channel, payload)
+	return mapError(err)
+}
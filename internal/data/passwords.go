@@ -0,0 +1,179 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordAlgorithmBcrypt   = "bcrypt"
+	PasswordAlgorithmArgon2id = "argon2id"
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordParams decide how new password hashes are made. Hashes are stored in a self
+// describing format, bcrypt's own "$2a$cost$..." or the PHC string format for Argon2id,
+// so hashes made with older settings keep working and are upgraded on sign in.
+type PasswordParams struct {
+	Algorithm string
+
+	BcryptCost int
+
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+var DefaultPasswordParams = PasswordParams{
+	Algorithm:         PasswordAlgorithmArgon2id,
+	BcryptCost:        12,
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  3,
+	Argon2Parallelism: 2,
+}
+
+var passwordParams = DefaultPasswordParams
+
+// SetPasswordParams changes how new password hashes are made. It should be called once,
+// before the server starts.
+func SetPasswordParams(params PasswordParams) error {
+	switch params.Algorithm {
+	case PasswordAlgorithmBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case PasswordAlgorithmArgon2id:
+		if params.Argon2Memory < 8*uint32(params.Argon2Parallelism) || params.Argon2Iterations < 1 || params.Argon2Parallelism < 1 {
+			return errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+		}
+	default:
+		return fmt.Errorf("unsupported password algorithm %q", params.Algorithm)
+	}
+
+	passwordParams = params
+	return nil
+}
+
+// maxPasswordLength bounds the work of hashing any password, whatever the algorithm.
+const maxPasswordLength = 256
+
+// maxNewPasswordLength is the longest new password the current algorithm can hash.
+// bcrypt ignores everything after 72 bytes, so it refuses longer passwords outright.
+func maxNewPasswordLength() int {
+	if passwordParams.Algorithm == PasswordAlgorithmBcrypt {
+		return 72
+	}
+
+	return maxPasswordLength
+}
+
+func hashPassword(plaintext string, params PasswordParams) ([]byte, error) {
+	if params.Algorithm == PasswordAlgorithmBcrypt {
+		return bcrypt.GenerateFromPassword([]byte(plaintext), params.BcryptCost)
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, 32)
+
+	return []byte(fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Argon2Memory,
+		params.Argon2Iterations,
+		params.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)), nil
+}
+
+// argon2idHash is a parsed "$argon2id$v=19$m=65536,t=3,p=2$salt$key" string.
+type argon2idHash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func isArgon2idHash(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func parseArgon2idHash(hash []byte) (*argon2idHash, error) {
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 {
+		return nil, errInvalidPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, errInvalidPasswordHash
+	}
+
+	var h argon2idHash
+
+	// argon2 panics on zero iterations or threads, so those are refused here rather
+	// than when the hash is checked.
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism)
+	if err != nil || h.iterations < 1 || h.parallelism < 1 {
+		return nil, errInvalidPasswordHash
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return nil, errInvalidPasswordHash
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil || len(h.key) == 0 {
+		return nil, errInvalidPasswordHash
+	}
+
+	return &h, nil
+}
+
+func (h *argon2idHash) matches(plaintext string) bool {
+	key := argon2.IDKey([]byte(plaintext), h.salt, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// NeedsRehash reports whether the hash was made with a different algorithm or weaker
+// settings than the current ones, in which case it should be replaced the next time
+// the plaintext is known.
+func (p *password) NeedsRehash() bool {
+	params := passwordParams
+
+	if isArgon2idHash(p.hash) {
+		if params.Algorithm != PasswordAlgorithmArgon2id {
+			return true
+		}
+
+		h, err := parseArgon2idHash(p.hash)
+		if err != nil {
+			return true
+		}
+
+		return h.memory != params.Argon2Memory || h.iterations != params.Argon2Iterations || h.parallelism != params.Argon2Parallelism
+	}
+
+	if params.Algorithm != PasswordAlgorithmBcrypt {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(p.hash)
+	return err != nil || cost != params.BcryptCost
+}
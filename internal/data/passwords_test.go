@@ -0,0 +1,202 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"strings"
+	"testing"
+)
+
+// testArgon2Params are weak enough to keep the tests fast.
+var testArgon2Params = PasswordParams{
+	Algorithm:         PasswordAlgorithmArgon2id,
+	BcryptCost:        bcrypt.MinCost,
+	Argon2Memory:      64,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 1,
+}
+
+// setPasswordParams swaps the package's password settings for the length of a test.
+func setPasswordParams(t *testing.T, params PasswordParams) {
+	t.Helper()
+
+	previous := passwordParams
+	t.Cleanup(func() { passwordParams = previous })
+
+	err := SetPasswordParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseArgon2idHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		want    argon2idHash
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ$a2V5a2V5",
+			want: argon2idHash{memory: 65536, iterations: 3, parallelism: 2, salt: []byte("saltsalt"), key: []byte("keykey")},
+		},
+		{name: "too few fields", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ", wantErr: true},
+		{name: "too many fields", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ$a2V5a2V5$", wantErr: true},
+		{name: "old version", hash: "$argon2id$v=16$m=65536,t=3,p=2$c2FsdHNhbHQ$a2V5a2V5", wantErr: true},
+		{name: "missing version", hash: "$argon2id$m=65536,t=3,p=2$c2FsdHNhbHQ$a2V5a2V5$", wantErr: true},
+		{name: "params out of order", hash: "$argon2id$v=19$t=3,m=65536,p=2$c2FsdHNhbHQ$a2V5a2V5", wantErr: true},
+		{name: "parallelism overflows", hash: "$argon2id$v=19$m=65536,t=3,p=256$c2FsdHNhbHQ$a2V5a2V5", wantErr: true},
+		{name: "zero iterations", hash: "$argon2id$v=19$m=65536,t=0,p=2$c2FsdHNhbHQ$a2V5a2V5", wantErr: true},
+		{name: "zero parallelism", hash: "$argon2id$v=19$m=65536,t=3,p=0$c2FsdHNhbHQ$a2V5a2V5", wantErr: true},
+		{name: "padded base64", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ=$a2V5a2V5", wantErr: true},
+		{name: "empty key", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ$", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgon2idHash([]byte(tt.hash))
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidPasswordHash) {
+					t.Fatalf("got %+v, %v; want errInvalidPasswordHash", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.memory != tt.want.memory || got.iterations != tt.want.iterations || got.parallelism != tt.want.parallelism ||
+				!bytes.Equal(got.salt, tt.want.salt) || !bytes.Equal(got.key, tt.want.key) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordMatches(t *testing.T) {
+	for _, algorithm := range []string{PasswordAlgorithmArgon2id, PasswordAlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			params := testArgon2Params
+			params.Algorithm = algorithm
+			setPasswordParams(t, params)
+
+			var p password
+			err := p.Set("pa55word")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for plaintext, want := range map[string]bool{"pa55word": true, "pa55word ": false, "": false} {
+				got, err := p.Matches(plaintext)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("Matches(%q) = %t; want %t", plaintext, got, want)
+				}
+			}
+		})
+	}
+
+	p := password{hash: []byte("$argon2id$v=19$m=65536,t=0,p=2$c2FsdHNhbHQ$a2V5a2V5")}
+	if _, err := p.Matches("pa55word"); err == nil {
+		t.Error("Matches accepted a hash with zero iterations")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	hashWith := func(params PasswordParams) password {
+		t.Helper()
+
+		hash, err := hashPassword("pa55word", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return password{hash: hash}
+	}
+
+	bcryptParams := testArgon2Params
+	bcryptParams.Algorithm = PasswordAlgorithmBcrypt
+
+	moreMemory := testArgon2Params
+	moreMemory.Argon2Memory *= 2
+
+	moreIterations := testArgon2Params
+	moreIterations.Argon2Iterations++
+
+	moreThreads := testArgon2Params
+	moreThreads.Argon2Parallelism++
+	moreThreads.Argon2Memory = 8 * uint32(moreThreads.Argon2Parallelism)
+
+	higherCost := bcryptParams
+	higherCost.BcryptCost++
+
+	tests := []struct {
+		name    string
+		hash    password
+		current PasswordParams
+		want    bool
+	}{
+		{name: "argon2id with current params", hash: hashWith(testArgon2Params), current: testArgon2Params, want: false},
+		{name: "argon2id with less memory", hash: hashWith(testArgon2Params), current: moreMemory, want: true},
+		{name: "argon2id with fewer iterations", hash: hashWith(testArgon2Params), current: moreIterations, want: true},
+		{name: "argon2id with fewer threads", hash: hashWith(testArgon2Params), current: moreThreads, want: true},
+		{name: "argon2id with stronger params", hash: hashWith(moreIterations), current: testArgon2Params, want: true},
+		{name: "argon2id when bcrypt is current", hash: hashWith(testArgon2Params), current: bcryptParams, want: true},
+		{name: "unparseable argon2id", hash: password{hash: []byte("$argon2id$v=19$garbage")}, current: testArgon2Params, want: true},
+		{name: "bcrypt with current cost", hash: hashWith(bcryptParams), current: bcryptParams, want: false},
+		{name: "bcrypt with lower cost", hash: hashWith(bcryptParams), current: higherCost, want: true},
+		{name: "bcrypt when argon2id is current", hash: hashWith(bcryptParams), current: testArgon2Params, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPasswordParams(t, tt.current)
+
+			if got := tt.hash.NeedsRehash(); got != tt.want {
+				t.Errorf("NeedsRehash() = %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordLengthLimits(t *testing.T) {
+	bcryptParams := testArgon2Params
+	bcryptParams.Algorithm = PasswordAlgorithmBcrypt
+
+	tests := []struct {
+		name       string
+		current    PasswordParams
+		password   string
+		wantNew    bool
+		wantSignIn bool
+	}{
+		{name: "short", current: testArgon2Params, password: "short", wantNew: false, wantSignIn: true},
+		{name: "over 72 bytes with argon2id", current: testArgon2Params, password: strings.Repeat("a", 100), wantNew: true, wantSignIn: true},
+		{name: "over 72 bytes with bcrypt", current: bcryptParams, password: strings.Repeat("a", 100), wantNew: false, wantSignIn: true},
+		{name: "over the limit", current: testArgon2Params, password: strings.Repeat("a", maxPasswordLength+1), wantNew: false, wantSignIn: false},
+		{name: "empty", current: testArgon2Params, password: "", wantNew: false, wantSignIn: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPasswordParams(t, tt.current)
+
+			v := validator.New()
+			ValidatePasswordPlaintext(v, "password", tt.password)
+			if v.Valid() != tt.wantNew {
+				t.Errorf("as a new password got %v; want valid: %t", v.Errors, tt.wantNew)
+			}
+
+			v = validator.New()
+			ValidateSignInPassword(v, tt.password)
+			if v.Valid() != tt.wantSignIn {
+				t.Errorf("signing in got %v; want valid: %t", v.Errors, tt.wantSignIn)
+			}
+		})
+	}
+}
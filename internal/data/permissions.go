@@ -3,34 +3,185 @@ package data
 import (
 	"context"
 	"github.com/lib/pq"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"regexp"
+	"strings"
 	"time"
 )
 
-type Permissions []string
+// PermissionWildcard matches any resource or any action in a permission code, so
+// "movies:*" covers every movies permission and "*:read" every read permission.
+const PermissionWildcard = "*"
 
+var permissionCodeRX = regexp.MustCompile(`^(\*|[a-z][a-z0-9_-]*):(\*|[a-z][a-z0-9_-]*)$`)
+
+// impliedActions lists the actions that holding an action also grants on the same
+// resource.
+var impliedActions = map[string][]string{
+	"admin": {"write"},
+	"write": {"read"},
+}
+
+type (
+	// Permissions is a list of permission codes of the form "resource:action". Either
+	// part of a code may be PermissionWildcard.
+	Permissions []string
+
+	// Permission is an entry in the catalogue of concrete permissions.
+	Permission struct {
+		Code        string      `json:"code"`
+		Description string      `json:"description"`
+		Implies     Permissions `json:"implies"`
+	}
+
+	IPermissionModel interface {
+		GetAll() ([]*Permission, error)
+		GetAllForUser(userID int64) (Permissions, error)
+		AddForUser(userID int64, codes ...string) error
+		RemoveForUser(userID int64, codes ...string) error
+	}
+)
+
+// Include reports whether the permissions cover code, either directly, through a
+// wildcard or through an implied action. When code is itself a pattern it is only
+// covered by permissions that are at least as broad.
 func (p Permissions) Include(code string) bool {
 	for i := range p {
-		if code == p[i] {
+		if coversPermission(p[i], code) {
+			return true
+		}
+	}
+	return false
+}
+
+func coversPermission(held, code string) bool {
+	heldResource, heldAction, _ := strings.Cut(held, ":")
+	resource, action, _ := strings.Cut(code, ":")
+
+	if heldResource != PermissionWildcard && heldResource != resource {
+		return false
+	}
+
+	return coversAction(heldAction, action)
+}
+
+func coversAction(held, action string) bool {
+	if held == PermissionWildcard || held == action {
+		return true
+	}
+
+	for _, implied := range impliedActions[held] {
+		if coversAction(implied, action) {
 			return true
 		}
 	}
+
 	return false
 }
 
+// Implied returns the concrete codes that holding code also grants.
+func Implied(code string) Permissions {
+	resource, action, _ := strings.Cut(code, ":")
+
+	implied := Permissions{}
+	for _, a := range impliedActions[action] {
+		implied = append(implied, resource+":"+a)
+		implied = append(implied, Implied(resource+":"+a)...)
+	}
+
+	return implied
+}
+
+func IsPermissionPattern(code string) bool {
+	return strings.Contains(code, PermissionWildcard)
+}
+
+// ValidatePermissionCodes checks that every code is well formed and covers at least one
+// of the known concrete permissions, so typos can't be granted.
+func ValidatePermissionCodes(v *validator.Validator, key string, codes Permissions, known []*Permission) {
+	v.Check(validator.Unique(codes), key, "must not contain duplicate values")
+
+	for _, code := range codes {
+		if !validator.Matches(code, permissionCodeRX) {
+			v.AddError(key, "must only contain codes of the form resource:action, where either part may be *")
+			continue
+		}
+
+		matched := false
+		for _, permission := range known {
+			if coversPermission(code, permission.Code) {
+				matched = true
+				break
+			}
+		}
+
+		v.Check(matched, key, "must only contain codes that match a known permission")
+	}
+}
+
+// GetAll returns the catalogue of concrete permissions. Wildcard patterns that have
+// been granted are left out.
+func (m PermissionModel) GetAll() ([]*Permission, error) {
+	query := `
+		SELECT code, description
+		FROM permissions
+		WHERE strpos(code, '*') = 0
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	permissions := []*Permission{}
+
+	for rows.Next() {
+		var permission Permission
+
+		err := rows.Scan(&permission.Code, &permission.Description)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		permission.Implies = Implied(permission.Code)
+		permissions = append(permissions, &permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return permissions, nil
+}
+
+// GetAllForUser returns the user's effective permissions: the ones granted to them
+// directly together with the ones bundled in their roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
-		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE permissions.id IN (
+			SELECT users_permissions.permission_id
+			FROM users_permissions
+			WHERE users_permissions.user_id = $1
+			UNION
+			SELECT roles_permissions.permission_id
+			FROM roles_permissions
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+			WHERE users_roles.user_id = $1
+		)
+		ORDER BY permissions.id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, err
+		return nil, mapError(err)
 	}
 	defer rows.Close()
 
@@ -41,27 +192,64 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 		err := rows.Scan(&permission)
 		if err != nil {
-			return nil, err
+			return nil, mapError(err)
 		}
 
 		permissions = append(permissions, permission)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	return permissions, nil
 }
 
+// AddForUser grants permissions to the user directly. Wildcard patterns are added to the
+// permissions table first, while unknown concrete codes and permissions the user
+// already has are ignored.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := insertPermissionPatterns(ctx, m.DB, codes)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return mapError(err)
+}
+
+// RemoveForUser revokes permissions that were granted to the user directly. It doesn't
+// touch the user's roles, so a permission that one of them bundles stays in effect.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1
+		AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	return mapError(err)
+}
+
+// insertPermissionPatterns adds a permissions row for each wildcard pattern in codes,
+// so it can be granted like any other permission.
+func insertPermissionPatterns(ctx context.Context, db DBTX, codes []string) error {
+	query := `
+		INSERT INTO permissions (code)
+		SELECT code FROM unnest($1::text[]) AS code
+		WHERE strpos(code, '*') > 0
+		ON CONFLICT (code) DO NOTHING`
+
+	_, err := db.ExecContext(ctx, query, pq.Array(codes))
+	return mapError(err)
 }
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"greenlight.badrchoubai.dev/internal/validator"
+	"reflect"
+	"testing"
+)
+
+func TestPermissionsInclude(t *testing.T) {
+	tests := []struct {
+		name string
+		held Permissions
+		code string
+		want bool
+	}{
+		{name: "exact", held: Permissions{"movies:read"}, code: "movies:read", want: true},
+		{name: "other action", held: Permissions{"movies:read"}, code: "movies:write", want: false},
+		{name: "other resource", held: Permissions{"movies:read"}, code: "users:read", want: false},
+		{name: "none held", held: nil, code: "movies:read", want: false},
+		{name: "action wildcard", held: Permissions{"movies:*"}, code: "movies:admin", want: true},
+		{name: "action wildcard stays on its resource", held: Permissions{"movies:*"}, code: "users:read", want: false},
+		{name: "resource wildcard", held: Permissions{"*:read"}, code: "users:read", want: true},
+		{name: "resource wildcard stays on its action", held: Permissions{"*:read"}, code: "users:write", want: false},
+		{name: "everything", held: Permissions{"*:*"}, code: "users:admin", want: true},
+		{name: "write implies read", held: Permissions{"movies:write"}, code: "movies:read", want: true},
+		{name: "admin implies read through write", held: Permissions{"movies:admin"}, code: "movies:read", want: true},
+		{name: "read implies nothing", held: Permissions{"movies:read"}, code: "movies:write", want: false},
+		{name: "implication stays on its resource", held: Permissions{"movies:admin"}, code: "users:read", want: false},
+		{name: "resource wildcard with implication", held: Permissions{"*:write"}, code: "users:read", want: true},
+		{name: "any of several", held: Permissions{"users:read", "movies:write"}, code: "movies:read", want: true},
+		{name: "pattern covered by a broader pattern", held: Permissions{"*:*"}, code: "movies:*", want: true},
+		{name: "pattern covered by the same pattern", held: Permissions{"movies:*"}, code: "movies:*", want: true},
+		{name: "pattern not covered by a concrete code", held: Permissions{"movies:admin"}, code: "movies:*", want: false},
+		{name: "resource pattern not covered by a concrete code", held: Permissions{"movies:read"}, code: "*:read", want: false},
+		{name: "resource pattern not covered by an action pattern", held: Permissions{"movies:*"}, code: "*:read", want: false},
+		{name: "malformed held code", held: Permissions{"movies"}, code: "movies:read", want: false},
+		{name: "prefix is not a match", held: Permissions{"movie:read"}, code: "movies:read", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.held.Include(tt.code); got != tt.want {
+				t.Errorf("%v.Include(%q) = %t; want %t", tt.held, tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImplied(t *testing.T) {
+	tests := []struct {
+		code string
+		want Permissions
+	}{
+		{code: "movies:read", want: Permissions{}},
+		{code: "movies:write", want: Permissions{"movies:read"}},
+		{code: "movies:admin", want: Permissions{"movies:write", "movies:read"}},
+	}
+
+	for _, tt := range tests {
+		if got := Implied(tt.code); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Implied(%q) = %v; want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePermissionCodes(t *testing.T) {
+	known := []*Permission{
+		{Code: "movies:read"},
+		{Code: "movies:write"},
+		{Code: "users:admin"},
+	}
+
+	tests := []struct {
+		name  string
+		codes Permissions
+		valid bool
+	}{
+		{name: "known codes", codes: Permissions{"movies:read", "users:admin"}, valid: true},
+		{name: "patterns that match", codes: Permissions{"movies:*", "*:admin", "*:*"}, valid: true},
+		{name: "empty", codes: Permissions{}, valid: true},
+		{name: "unknown code", codes: Permissions{"movies:delete"}, valid: false},
+		{name: "pattern that matches nothing", codes: Permissions{"tokens:*"}, valid: false},
+		{name: "duplicates", codes: Permissions{"movies:read", "movies:read"}, valid: false},
+		{name: "no action", codes: Permissions{"movies"}, valid: false},
+		{name: "partial wildcard", codes: Permissions{"movies:re*"}, valid: false},
+		{name: "upper case", codes: Permissions{"Movies:read"}, valid: false},
+		{name: "extra part", codes: Permissions{"movies:read:all"}, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidatePermissionCodes(v, "permissions", tt.codes, known)
+
+			if v.Valid() != tt.valid {
+				t.Errorf("valid = %t; want %t (errors: %v)", v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// RevokedToken is an entry in the deny-list of signed access tokens. Signed tokens
+	// can't be deleted like opaque ones, so revoking one means remembering its ID until
+	// it would have expired anyway.
+	RevokedToken struct {
+		ID     string
+		Expiry time.Time
+	}
+
+	// RevokedUserTokens revokes every signed access token issued to a user before
+	// IssuedBefore. It is how a user is signed out everywhere, since the IDs of the
+	// tokens they hold aren't known.
+	RevokedUserTokens struct {
+		UserID       int64
+		IssuedBefore time.Time
+		Expiry       time.Time
+	}
+
+	IRevokedTokenModel interface {
+		Insert(id string, expiry time.Time) error
+		InsertForUser(userID int64, issuedBefore, expiry time.Time) error
+		GetAllUnexpired() ([]*RevokedToken, error)
+		GetAllUnexpiredForUsers() ([]*RevokedUserTokens, error)
+		DeleteExpired(limit int) (int64, error)
+	}
+)
+
+func (model RevokedTokenModel) Insert(id string, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_access_tokens (id, expiry)
+		VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, id, expiry)
+	return mapError(err)
+}
+
+// InsertForUser revokes the user's signed access tokens issued before issuedBefore. An
+// earlier revocation for the user is only ever extended, never shortened.
+func (model RevokedTokenModel) InsertForUser(userID int64, issuedBefore, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_user_access_tokens (user_id, issued_before, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET issued_before = GREATEST(revoked_user_access_tokens.issued_before, EXCLUDED.issued_before),
+		    expiry = GREATEST(revoked_user_access_tokens.expiry, EXCLUDED.expiry)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, userID, issuedBefore, expiry)
+	return mapError(err)
+}
+
+func (model RevokedTokenModel) GetAllUnexpired() ([]*RevokedToken, error) {
+	query := `
+		SELECT id, expiry
+		FROM revoked_access_tokens
+		WHERE expiry > $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	revoked := []*RevokedToken{}
+
+	for rows.Next() {
+		var token RevokedToken
+
+		err := rows.Scan(&token.ID, &token.Expiry)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		revoked = append(revoked, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return revoked, nil
+}
+
+func (model RevokedTokenModel) GetAllUnexpiredForUsers() ([]*RevokedUserTokens, error) {
+	query := `
+		SELECT user_id, issued_before, expiry
+		FROM revoked_user_access_tokens
+		WHERE expiry > $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	revoked := []*RevokedUserTokens{}
+
+	for rows.Next() {
+		var tokens RevokedUserTokens
+
+		err := rows.Scan(&tokens.UserID, &tokens.IssuedBefore, &tokens.Expiry)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		revoked = append(revoked, &tokens)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpired deletes up to limit expired entries from each of the deny-lists, which
+// are only needed until the tokens they name have expired, and returns how many it
+// deleted in total.
+func (model RevokedTokenModel) DeleteExpired(limit int) (int64, error) {
+	query := `
+		WITH tokens AS (
+			DELETE FROM revoked_access_tokens
+			WHERE id IN (
+				SELECT id
+				FROM revoked_access_tokens
+				WHERE expiry < $1
+				LIMIT $2
+			)
+			RETURNING 1
+		), users AS (
+			DELETE FROM revoked_user_access_tokens
+			WHERE user_id IN (
+				SELECT user_id
+				FROM revoked_user_access_tokens
+				WHERE expiry < $1
+				LIMIT $2
+			)
+			RETURNING 1
+		)
+		SELECT (SELECT count(*) FROM tokens) + (SELECT count(*) FROM users)`
+
+	var deleted int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, time.Now(), limit).Scan(&deleted)
+	return deleted, mapError(err)
+}
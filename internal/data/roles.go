@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.badrchoubai.dev/internal/validator"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrDuplicateRoleName = errors.New("duplicate role name")
+
+	roleNameRX = regexp.MustCompile("^[a-z][a-z0-9_-]*$")
+)
+
+type (
+	// Role is a named bundle of permissions. A user assigned a role has every
+	// permission in it for as long as they keep the role.
+	Role struct {
+		ID          int64       `json:"id"`
+		Name        string      `json:"name"`
+		Permissions Permissions `json:"permissions"`
+		CreatedAt   time.Time   `json:"created_at"`
+	}
+
+	IRoleModel interface {
+		Insert(role *Role) error
+		Get(name string) (*Role, error)
+		GetAll() ([]*Role, error)
+		GetAllForUser(userID int64) ([]string, error)
+		AddForUser(userID int64, names ...string) error
+		RemoveForUser(userID int64, names ...string) error
+	}
+)
+
+// ValidateRole checks the role's name and that its permissions are valid codes that
+// match the known permissions.
+func ValidateRole(v *validator.Validator, role *Role, known []*Permission) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(role.Name == "" || validator.Matches(role.Name, roleNameRX), "name", "must start with a lowercase letter and contain only lowercase letters, digits, hyphens and underscores")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	ValidatePermissionCodes(v, "permissions", role.Permissions, known)
+}
+
+// Insert creates the role together with its permissions, so it must be called inside a
+// transaction.
+func (m RoleModel) Insert(role *Role) error {
+	query := `
+		INSERT INTO roles (name)
+		VALUES ($1)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, role.Name).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		return mapError(err)
+	}
+
+	err = insertPermissionPatterns(ctx, m.DB, role.Permissions)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO roles_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = m.DB.ExecContext(ctx, query, role.ID, pq.Array(role.Permissions))
+	return mapError(err)
+}
+
+func (m RoleModel) Get(name string) (*Role, error) {
+	query := `
+		SELECT roles.id, roles.name, roles.created_at,
+			COALESCE(array_agg(permissions.code ORDER BY permissions.id) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		WHERE roles.name = $1
+		GROUP BY roles.id`
+
+	var role Role
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&role.ID,
+		&role.Name,
+		&role.CreatedAt,
+		pq.Array(&role.Permissions),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, mapError(err)
+		}
+	}
+
+	return &role, nil
+}
+
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT roles.id, roles.name, roles.created_at,
+			COALESCE(array_agg(permissions.code ORDER BY permissions.id) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id
+		ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.CreatedAt,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return roles, nil
+}
+
+// GetAllForUser returns the names of the user's roles in alphabetical order.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return names, nil
+}
+
+// AddForUser assigns roles to the user. Unknown names and roles the user already has are
+// ignored.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return mapError(err)
+}
+
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+		DELETE FROM users_roles
+		WHERE user_id = $1
+		AND role_id IN (SELECT id FROM roles WHERE name = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return mapError(err)
+}
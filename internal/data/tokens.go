@@ -4,7 +4,10 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
+	"errors"
 	"greenlight.badrchoubai.dev/internal/validator"
 	"time"
 )
@@ -12,6 +15,11 @@ import (
 const (
 	ScopeActivation     = "user:activation"
 	ScopeAuthentication = "user:authentication"
+	ScopePasswordReset  = "user:password-reset"
+	ScopeEmailChange    = "user:email-change"
+	ScopeRefresh        = "user:refresh"
+	ScopeMFAPending     = "user:mfa-pending"
+	ScopeMagicLink      = "user:magic-link"
 )
 
 type (
@@ -21,62 +29,365 @@ type (
 		UserID    int64     `json:"-"`
 		Expiry    time.Time `json:"expiry"`
 		Scope     string    `json:"-"`
+		// Payload carries scope specific data, such as the pending address of an
+		// email change.
+		Payload string `json:"-"`
+
+		// ID is an opaque identifier that lets a user refer to one of their tokens,
+		// a session for example, without knowing its plaintext.
+		ID         string     `json:"-"`
+		CreatedAt  time.Time  `json:"-"`
+		LastUsedAt *time.Time `json:"-"`
+		UserAgent  string     `json:"-"`
+		IP         string     `json:"-"`
+
+		// Family links a refresh token to the tokens it was rotated into and to the
+		// access tokens issued alongside them, so they can be revoked together.
+		Family string `json:"-"`
+		// UsedAt is set once a refresh token has been rotated. Presenting it again
+		// means it has leaked.
+		UsedAt *time.Time `json:"-"`
 	}
 
 	ITokenModel interface {
 		New(userID int64, ttl time.Duration, scope string) (*Token, error)
+		NewWithPayload(userID int64, ttl time.Duration, scope, payload string) (*Token, error)
 		Insert(token *Token) error
+		Get(tokenScope, tokenPlaintext string) (*Token, error)
+		GetAllForUser(userID int64) ([]*Token, error)
+		Touch(tokenScope, tokenPlaintext string) error
+		MarkUsed(tokenScope, tokenPlaintext string) error
+		Delete(tokenScope, tokenPlaintext string) error
+		DeleteByID(userID int64, id string) error
+		DeleteFamily(family string) error
+		DeleteForFamily(scope, family string) error
 		DeleteAllForUser(scope string, userID int64) error
+		DeleteAllScopesForUser(userID int64) error
+		DeleteExpired(limit int) (int64, error)
 	}
 )
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+// GenerateToken creates a token without storing it, so callers can fill in the optional
+// fields before passing it to Insert.
+func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+		CreatedAt: time.Now(),
 	}
 
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+	var err error
+
+	token.Plaintext, token.Hash, err = generateTokenPlaintext()
 	if err != nil {
 		return nil, err
 	}
 
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.ID, err = randomID()
+	if err != nil {
+		return nil, err
+	}
 
-	token.Hash = hash[:]
 	return token, nil
 }
 
+// generateTokenPlaintext returns a random 26 character plaintext and the hash that is
+// stored in its place.
+func generateTokenPlaintext() (string, []byte, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return plaintext, hash[:], nil
+}
+
+// NewTokenFamily returns a new identifier for a family of tokens.
+func NewTokenFamily() (string, error) {
+	return randomID()
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
 func (model TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+	return model.NewWithPayload(userID, ttl, scope, "")
+}
+
+func (model TokenModel) NewWithPayload(userID int64, ttl time.Duration, scope, payload string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
+	token.Payload = payload
+
 	err = model.Insert(token)
 	return token, err
 }
 
 func (model TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, payload, id, created_at, user_agent, ip, family)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.Payload,
+		token.ID,
+		token.CreatedAt,
+		token.UserAgent,
+		token.IP,
+		token.Family,
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	_, err := model.DB.ExecContext(ctx, query, args...)
-	return err
+	return mapError(err)
+}
+
+// Get returns the unexpired token with the given scope and plaintext. The returned
+// token doesn't include the plaintext.
+func (model TokenModel) Get(tokenScope, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT hash, user_id, expiry, scope, payload, id, created_at, last_used_at, user_agent, ip, family, used_at
+		FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > $3`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var token Token
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.Payload,
+		&token.ID,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.UserAgent,
+		&token.IP,
+		&token.Family,
+		&token.UsedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, mapError(err)
+		}
+	}
+
+	return &token, nil
+}
+
+// GetAllForUser returns the unexpired tokens of every scope that belong to the user,
+// soonest to expire first.
+func (model TokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	query := `
+		SELECT hash, user_id, expiry, scope, payload, id, created_at, last_used_at, user_agent, ip, family, used_at
+		FROM tokens
+		WHERE user_id = $1
+		AND expiry > $2
+		ORDER BY expiry ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var token Token
+
+		err := rows.Scan(
+			&token.Hash,
+			&token.UserID,
+			&token.Expiry,
+			&token.Scope,
+			&token.Payload,
+			&token.ID,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.UserAgent,
+			&token.IP,
+			&token.Family,
+			&token.UsedAt,
+		)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, mapError(err)
+	}
+
+	return tokens, nil
+}
+
+// Touch records that the token was just used. To keep the write cheap on busy sessions
+// the timestamp is only moved forward once a minute.
+func (model TokenModel) Touch(tokenScope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1 AND scope = $2
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, tokenHash[:], tokenScope)
+	return mapError(err)
+}
+
+// MarkUsed records that a token has been used up. It returns ErrEditConflict if the
+// token was already marked, so two concurrent requests can't both use it.
+func (model TokenModel) MarkUsed(tokenScope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1 AND scope = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, tokenHash[:], tokenScope)
+	if err != nil {
+		return mapError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func (model TokenModel) Delete(tokenScope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, tokenHash[:], tokenScope)
+	return mapError(err)
+}
+
+// DeleteByID deletes one of the user's tokens by its opaque ID, along with the rest of
+// its family. It returns ErrRecordNotFound if the user has no token with that ID.
+func (model TokenModel) DeleteByID(userID int64, id string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		AND (id = $2 OR family IN (
+			SELECT family FROM tokens WHERE user_id = $1 AND id = $2 AND family <> ''
+		))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (model TokenModel) DeleteFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, family)
+	return mapError(err)
+}
+
+func (model TokenModel) DeleteForFamily(scope, family string) error {
+	if family == "" {
+		return nil
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND family = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, scope, family)
+	return mapError(err)
 }
 
 func (model TokenModel) DeleteAllForUser(scope string, userID int64) error {
@@ -88,5 +399,40 @@ func (model TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	defer cancel()
 
 	_, err := model.DB.ExecContext(ctx, query, scope, userID)
-	return err
+	return mapError(err)
+}
+
+func (model TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, userID)
+	return mapError(err)
+}
+
+// DeleteExpired deletes up to limit tokens that have expired and returns how many it
+// deleted. Keeping each call bounded keeps the locks it takes short lived.
+func (model TokenModel) DeleteExpired(limit int) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash
+			FROM tokens
+			WHERE expiry < $1
+			LIMIT $2
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, time.Now(), limit)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return result.RowsAffected()
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"greenlight.badrchoubai.dev/internal/validator"
 	"time"
@@ -29,13 +30,23 @@ type (
 		Name      string    `json:"name"`
 		Password  password  `json:"-"`
 		Version   int       `json:"-"`
+		// ScheduledDeletionAt is set while the account is waiting out the grace period
+		// before it is permanently deleted.
+		ScheduledDeletionAt *time.Time `json:"scheduled_deletion_at,omitempty"`
+		// DisabledAt is set while an admin has disabled the account. Unlike an account
+		// that hasn't been activated yet, a disabled one can't be activated or signed in
+		// to until an admin enables it again.
+		DisabledAt *time.Time `json:"disabled_at,omitempty"`
 	}
 
 	IUserModel interface {
 		Insert(user *User) error
 		GetForToken(tokenScope, tokenPlaintext string) (*User, error)
+		Get(id int64) (*User, error)
 		GetByEmail(email string) (*User, error)
+		GetAll(search string, activated *bool, filters FilterOptions) ([]*User, Metadata, error)
 		Update(user *User) error
+		DeleteScheduled(before time.Time) (int64, error)
 	}
 )
 
@@ -43,6 +54,10 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
 func (model UserModel) Insert(user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
@@ -59,16 +74,7 @@ func (model UserModel) Insert(user *User) error {
 		&user.CreatedAt,
 		&user.Version,
 	)
-	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
-		}
-	}
-
-	return nil
+	return mapError(err)
 }
 
 func (model UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
@@ -76,7 +82,7 @@ func (model UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, er
 
 	// Set up the SQL query.
 	query := `
-        SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+        SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.scheduled_deletion_at, users.disabled_at
         FROM users
         INNER JOIN tokens
         ON users.id = tokens.user_id
@@ -99,6 +105,8 @@ func (model UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, er
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.ScheduledDeletionAt,
+		&user.DisabledAt,
 	)
 
 	if err != nil {
@@ -106,7 +114,46 @@ func (model UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, er
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, mapError(err)
+		}
+	}
+
+	return &user, nil
+}
+
+func (model UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version, scheduled_deletion_at, disabled_at
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.ScheduledDeletionAt,
+		&user.DisabledAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, mapError(err)
 		}
 	}
 
@@ -115,7 +162,7 @@ func (model UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, er
 
 func (model UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version, scheduled_deletion_at, disabled_at
 		FROM users
 		WHERE email = $1`
 
@@ -132,6 +179,8 @@ func (model UserModel) GetByEmail(email string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.ScheduledDeletionAt,
+		&user.DisabledAt,
 	)
 
 	if err != nil {
@@ -139,18 +188,72 @@ func (model UserModel) GetByEmail(email string) (*User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, mapError(err)
 		}
 	}
 
 	return &user, nil
 }
 
+// GetAll returns a page of users whose email address or name contains search, ignoring
+// case. A nil activated matches users in either state.
+func (model UserModel) GetAll(search string, activated *bool, filters FilterOptions) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, email, password_hash, activated, version, scheduled_deletion_at, disabled_at
+		FROM users
+		WHERE (strpos(lower(email::text), lower($1)) > 0 OR strpos(lower(name), lower($1)) > 0 OR $1 = '')
+		AND (activated = $2 OR $2 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, search, activated, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, mapError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+			&user.ScheduledDeletionAt,
+			&user.DisabledAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, mapError(err)
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, mapError(err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
 func (model UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, scheduled_deletion_at = $5, disabled_at = $6, version = version + 1
+		WHERE id = $7 AND version = $8
 		RETURNING version`
 
 	args := []any{
@@ -158,6 +261,8 @@ func (model UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.ScheduledDeletionAt,
+		user.DisabledAt,
 		user.ID,
 		user.Version,
 	}
@@ -169,20 +274,37 @@ func (model UserModel) Update(user *User) error {
 
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return mapError(err)
 		}
 	}
 
 	return nil
 }
 
+// DeleteScheduled permanently deletes every user whose scheduled deletion time is before
+// the given time, returning how many were deleted. Their tokens and permissions go with
+// them through the ON DELETE CASCADE foreign keys.
+func (model UserModel) DeleteScheduled(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE scheduled_deletion_at <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return result.RowsAffected()
+}
+
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := hashPassword(plaintextPassword, passwordParams)
 	if err != nil {
 		return err
 	}
@@ -194,6 +316,15 @@ func (p *password) Set(plaintextPassword string) error {
 }
 
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if isArgon2idHash(p.hash) {
+		h, err := parseArgon2idHash(p.hash)
+		if err != nil {
+			return false, err
+		}
+
+		return h.matches(plaintextPassword), nil
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -212,23 +343,37 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be valid")
 }
 
-func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+// ValidatePasswordPlaintext checks a new password, reporting any problems under key.
+func ValidatePasswordPlaintext(v *validator.Validator, key, password string) {
+	v.Check(password != "", key, "must be provided")
+	v.Check(len(password) >= 8, key, "must be at least 8 characters long")
+	v.Check(len(password) <= maxNewPasswordLength(), key, fmt.Sprintf("must not exceed max length (%d characters)", maxNewPasswordLength()))
+}
+
+// ValidateSignInPassword checks a password someone signs in with. Its limit doesn't
+// depend on the current algorithm, so passwords hashed by an earlier one keep working.
+func ValidateSignInPassword(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 characters long")
-	v.Check(len(password) <= 72, "password", "must not exceed max length (72 characters)")
+	v.Check(len(password) <= maxPasswordLength, "password", fmt.Sprintf("must not exceed max length (%d characters)", maxPasswordLength))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 50, "name", "must not be more than 50 characters long")
-
-	ValidateEmail(v, user.Email)
+	ValidateUserDetails(v, user)
 
 	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordPlaintext(v, "password", *user.Password.plaintext)
 	}
 
 	if user.Password.hash == nil {
 		panic("missing password hash for user")
 	}
 }
+
+// ValidateUserDetails checks everything about a user but their password, so a new
+// password can be validated on its own before the work of hashing it.
+func ValidateUserDetails(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 50, "name", "must not be more than 50 characters long")
+
+	ValidateEmail(v, user.Email)
+}
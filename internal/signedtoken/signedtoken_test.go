@@ -0,0 +1,236 @@
+package signedtoken
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	hmacSecret  = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	ed25519Seed = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func mustParseKey(t *testing.T, spec string) *Key {
+	t.Helper()
+
+	key, err := ParseKey(spec)
+	if err != nil {
+		t.Fatalf("ParseKey(%q): %v", spec, err)
+	}
+
+	return key
+}
+
+func mustNewSigner(t *testing.T, currentKeyID string, keys ...*Key) *Signer {
+	t.Helper()
+
+	s, err := NewSigner(keys, currentKeyID)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+
+	return s
+}
+
+// forge signs claims with key but writes header into the token, so tests can check
+// what Verify does with headers a legitimate signer would never produce.
+func forge(t *testing.T, key *Key, h header, claims Claims) string {
+	t.Helper()
+
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := encoding.EncodeToString(hb) + "." + encoding.EncodeToString(cb)
+
+	return signingInput + "." + encoding.EncodeToString(key.sign([]byte(signingInput)))
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		algorithm string
+		wantErr   bool
+	}{
+		{name: "hs256", spec: "k1:hs256:" + hmacSecret, algorithm: AlgorithmHS256},
+		{name: "algorithm is case insensitive", spec: "k1:HS256:" + hmacSecret, algorithm: AlgorithmHS256},
+		{name: "ed25519", spec: "k1:ed25519:" + ed25519Seed, algorithm: AlgorithmEd25519},
+		{name: "missing parts", spec: "k1:hs256", wantErr: true},
+		{name: "empty kid", spec: ":hs256:" + hmacSecret, wantErr: true},
+		{name: "bad base64", spec: "k1:hs256:not base64!", wantErr: true},
+		{name: "short hmac secret", spec: "k1:hs256:" + base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{name: "short ed25519 seed", spec: "k1:ed25519:" + hmacSecret[:20], wantErr: true},
+		{name: "unsupported algorithm", spec: "k1:rs256:" + hmacSecret, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKey(%q) = %+v; want an error", tt.spec, key)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseKey(%q): %v", tt.spec, err)
+			}
+
+			if key.ID != "k1" || key.Algorithm != tt.algorithm {
+				t.Errorf("got kid %q alg %q; want k1 %s", key.ID, key.Algorithm, tt.algorithm)
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	k1 := mustParseKey(t, "k1:hs256:"+hmacSecret)
+
+	if _, err := NewSigner([]*Key{k1, k1}, "k1"); err == nil {
+		t.Error("NewSigner accepted a duplicate key id")
+	}
+
+	if _, err := NewSigner([]*Key{k1}, "k2"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v; want ErrUnknownKey", err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	hs := mustParseKey(t, "hs:hs256:"+hmacSecret)
+	ed := mustParseKey(t, "ed:ed25519:"+ed25519Seed)
+	other := mustParseKey(t, "hs:hs256:"+base64.StdEncoding.EncodeToString([]byte("another secret that is long enough")))
+
+	claims := Claims{
+		ID:          "jti",
+		Subject:     42,
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+		Family:      "fam",
+		IssuedAt:    now.Unix(),
+		Expiry:      now.Add(time.Minute).Unix(),
+	}
+
+	sign := func(s *Signer, c Claims) string {
+		token, err := s.Sign(c)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return token
+	}
+
+	hsSigner := mustNewSigner(t, "hs", hs, ed)
+	edSigner := mustNewSigner(t, "ed", hs, ed)
+
+	expired := claims
+	expired.Expiry = now.Unix()
+
+	tamperedClaims := claims
+	tamperedClaims.Subject = 1
+	hsToken := sign(hsSigner, claims)
+	parts := strings.Split(hsToken, ".")
+	tampered := parts[0] + "." + encoding.EncodeToString(mustJSON(t, tamperedClaims)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		token   string
+		wantErr error
+	}{
+		{name: "hs256", signer: hsSigner, token: hsToken},
+		{name: "ed25519", signer: edSigner, token: sign(edSigner, claims)},
+		{name: "rotated key still verifies", signer: edSigner, token: hsToken},
+		{name: "expired", signer: hsSigner, token: sign(hsSigner, expired), wantErr: ErrExpiredToken},
+		{name: "tampered claims", signer: hsSigner, token: tampered, wantErr: ErrInvalidToken},
+		{name: "signed by a different secret", signer: hsSigner, token: sign(mustNewSigner(t, "hs", other), claims), wantErr: ErrInvalidToken},
+		{name: "unknown kid", signer: mustNewSigner(t, "ed", ed), token: hsToken, wantErr: ErrUnknownKey},
+		{
+			// The HMAC is computed with the right secret, but the header claims a
+			// different algorithm than the key is registered with.
+			name:    "wrong alg for kid",
+			signer:  hsSigner,
+			token:   forge(t, hs, header{Algorithm: AlgorithmEd25519, KeyID: "hs", Type: "JWT"}, claims),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "alg none",
+			signer:  hsSigner,
+			token:   forge(t, hs, header{Algorithm: "none", KeyID: "hs", Type: "JWT"}, claims),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			// An Ed25519 key's public half must not be usable as an HMAC secret.
+			name:    "hs256 header on an ed25519 kid",
+			signer:  edSigner,
+			token:   forge(t, &Key{Algorithm: AlgorithmHS256, secret: ed.publicKey}, header{Algorithm: AlgorithmHS256, KeyID: "ed", Type: "JWT"}, claims),
+			wantErr: ErrInvalidToken,
+		},
+		{name: "two segments", signer: hsSigner, token: parts[0] + "." + parts[1], wantErr: ErrInvalidToken},
+		{name: "bad header", signer: hsSigner, token: "!!." + parts[1] + "." + parts[2], wantErr: ErrInvalidToken},
+		{name: "bad signature encoding", signer: hsSigner, token: parts[0] + "." + parts[1] + ".!!", wantErr: ErrInvalidToken},
+		{name: "empty", signer: hsSigner, token: "", wantErr: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.Verify(tt.token, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify: got %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if got.Subject != claims.Subject || got.ID != claims.ID || got.Family != claims.Family ||
+				!got.Activated || len(got.Permissions) != 1 || got.Permissions[0] != "movies:read" {
+				t.Errorf("got claims %+v; want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestLooksSigned(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{token: "a.b.c", want: true},
+		{token: "ABCDEFGHIJKLMNOPQRSTUVWXYZ", want: false},
+		{token: "a.b", want: false},
+		{token: "a.b.c.d", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := LooksSigned(tt.token); got != tt.want {
+			t.Errorf("LooksSigned(%q) = %t; want %t", tt.token, got, tt.want)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
@@ -0,0 +1,97 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from the test vectors in RFC 6238 appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The RFC lists 8-digit codes; the 6-digit codes are their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if got != tt.want {
+			t.Errorf("Code at %d = %s; want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Counter(now)
+
+	tests := []struct {
+		name        string
+		code        string
+		wantCounter int64
+		wantOK      bool
+	}{
+		{name: "current period", code: Code(rfcSecret, current), wantCounter: current, wantOK: true},
+		{name: "previous period", code: Code(rfcSecret, current-1), wantCounter: current - 1, wantOK: true},
+		{name: "next period", code: Code(rfcSecret, current+1), wantCounter: current + 1, wantOK: true},
+		{name: "two periods ago", code: Code(rfcSecret, current-2)},
+		{name: "two periods ahead", code: Code(rfcSecret, current+2)},
+		{name: "wrong code", code: "000000"},
+		{name: "too short", code: Code(rfcSecret, current)[:5]},
+		{name: "too long", code: Code(rfcSecret, current) + "0"},
+		{name: "empty", code: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Two periods either side can collide with a neighbouring code by chance,
+			// which would make the test meaningless rather than wrong.
+			if !tt.wantOK && tt.code != "000000" {
+				for counter := current - skew; counter <= current+skew; counter++ {
+					if Code(rfcSecret, counter) == tt.code {
+						t.Skip("code collides with one inside the window")
+					}
+				}
+			}
+
+			counter, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || counter != tt.wantCounter {
+				t.Errorf("Validate(%q) = %d, %t; want %d, %t", tt.code, counter, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEncodeSecret(t *testing.T) {
+	got := EncodeSecret(rfcSecret)
+	if want := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"; got != want {
+		t.Errorf("EncodeSecret = %s; want %s", got, want)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Greenlight", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || !strings.HasSuffix(u.Path, "Greenlight:alice@example.com") {
+		t.Errorf("unexpected URI %s", u)
+	}
+
+	params := u.Query()
+	if params.Get("secret") != EncodeSecret(rfcSecret) || params.Get("digits") != "6" || params.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_scheduled_deletion_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS scheduled_deletion_at;
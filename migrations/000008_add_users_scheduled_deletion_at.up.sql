@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scheduled_deletion_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS users_scheduled_deletion_at_idx ON users (scheduled_deletion_at) WHERE scheduled_deletion_at IS NOT NULL;
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+  id text PRIMARY KEY,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'apikeys:manage';
+
+DROP TABLE IF EXISTS api_keys;
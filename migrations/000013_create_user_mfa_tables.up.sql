@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  secret bytea NOT NULL,
+  confirmed_at timestamp(0) with time zone,
+  last_used_counter bigint NOT NULL DEFAULT 0,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  hash bytea NOT NULL,
+  PRIMARY KEY (user_id, hash)
+);
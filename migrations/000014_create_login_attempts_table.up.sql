@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id bigserial PRIMARY KEY,
+  email citext NOT NULL,
+  ip text NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_created_at_idx ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_created_at_idx ON login_attempts (ip, created_at);
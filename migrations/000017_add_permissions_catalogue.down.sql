@@ -0,0 +1,11 @@
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code NOT LIKE '%*%'
+ON CONFLICT DO NOTHING;
+
+DELETE FROM permissions WHERE code LIKE '%*%';
+
+DROP INDEX IF EXISTS permissions_code_key;
+
+ALTER TABLE permissions DROP COLUMN IF EXISTS description;
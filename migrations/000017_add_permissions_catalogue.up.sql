@@ -0,0 +1,24 @@
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_key ON permissions (code);
+
+UPDATE permissions SET description = CASE code
+  WHEN 'movies:read' THEN 'Read movies'
+  WHEN 'movies:write' THEN 'Create, update and delete movies'
+  WHEN 'apikeys:manage' THEN 'Create and revoke API keys'
+  WHEN 'users:admin' THEN 'Manage user accounts, roles and permissions'
+  ELSE description
+END;
+
+-- The admin role holds every permission, including ones added later.
+INSERT INTO permissions (code)
+VALUES ('*:*')
+ON CONFLICT (code) DO NOTHING;
+
+DELETE FROM roles_permissions
+WHERE role_id = (SELECT id FROM roles WHERE name = 'admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = '*:*';
@@ -0,0 +1,6 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_by bigint REFERENCES users ON DELETE SET NULL;
+
+INSERT INTO permissions (code, description)
+VALUES ('movies:admin', 'Update and delete movies created by anyone')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS invitations (
+  id bigserial PRIMARY KEY,
+  hash bytea NOT NULL,
+  email citext NOT NULL,
+  permissions text[] NOT NULL DEFAULT '{}',
+  created_by bigint REFERENCES users ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expiry timestamp(0) with time zone NOT NULL,
+  CONSTRAINT invitations_hash_key UNIQUE (hash)
+);
+
+CREATE INDEX IF NOT EXISTS invitations_email_idx ON invitations (email);
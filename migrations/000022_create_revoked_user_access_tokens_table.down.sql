@@ -0,0 +1 @@
+DROP TABLE IF EXISTS revoked_user_access_tokens;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS revoked_user_access_tokens (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  issued_before timestamp(0) with time zone NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS revoked_user_access_tokens_expiry_idx;
+DROP INDEX IF EXISTS revoked_access_tokens_expiry_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS revoked_access_tokens_expiry_idx ON revoked_access_tokens (expiry);
+CREATE INDEX IF NOT EXISTS revoked_user_access_tokens_expiry_idx ON revoked_user_access_tokens (expiry);
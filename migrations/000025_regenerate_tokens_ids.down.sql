@@ -0,0 +1 @@
+-- The old IDs gave away part of each token's hash, so they aren't restored.
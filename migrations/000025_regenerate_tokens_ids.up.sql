@@ -0,0 +1 @@
+UPDATE tokens SET id = replace(gen_random_uuid()::text, '-', '') WHERE id = encode(substring(hash FROM 1 FOR 10), 'hex');